@@ -5,25 +5,44 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ANSI color codes, blanked by -no-color
+var (
+	Red     = "\033[1;31m"
+	Green   = "\033[1;32m"
+	Yellow  = "\033[1;33m"
+	Blue    = "\033[1;34m"
+	Magenta = "\033[1;35m"
+	Dim     = "\033[2m"
+	Reset   = "\033[0m"
 )
 
-// ANSI color codes
 const (
-	Red    = "\033[1;31m"
-	Green  = "\033[1;32m"
-	Yellow = "\033[1;33m"
-	Blue   = "\033[1;34m"
-	Reset  = "\033[0m"
 	// System prompt enforcing naming/backstory rules
 	SYSTEM_PROMPT = `You are Realmweaver, the narrator and engine of an immersive, open‐ended text adventure.
 Whenever you describe people in a scene, ALWAYS give them:
@@ -40,10 +59,96 @@ respond with a vivid, immersive description or dialogue.
 ` // There are no quests—only exploration, conversation, and discovery.
 )
 
+// Built-in world themes selectable with -theme
+var themes = map[string]string{
+	"fantasy": SYSTEM_PROMPT,
+	"cyberpunk": `You are Overclock, the narrator and engine of an immersive, open‐ended cyberpunk text adventure.
+Whenever you describe people in a scene, ALWAYS give them:
+  1) A full name or street handle and role (e.g. "Mara 'Static' Voss, the Fixer").
+  2) A brief backstory snippet—one or two sentences about their past, implants, or allegiances.
+
+The world is a rain-slick megacity of neon arcologies, street markets, noodle bars,
+corporate towers, undercity tunnels, clinics, clubs, and the net beneath it all.
+
+Keep track of the player's location, the NPCs you've introduced (with consistent names),
+and ensure continuity as they walk, examine, or speak with people and machines.
+When the player types commands like "go to…", "examine…", or "talk to X",
+respond with a vivid, immersive description or dialogue.
+`,
+	"horror": `You are the Keeper, the narrator and engine of an immersive, open‐ended horror text adventure.
+Whenever you describe people in a scene, ALWAYS give them:
+  1) A full name and role (e.g. "Edith Marsh, the Lighthouse Keeper's Widow").
+  2) A brief backstory snippet—one or two sentences about their past, fears, or secrets.
+
+The world is a fog-bound coastal town of crooked houses, a shuttered church, the asylum on the hill,
+woods that swallow paths, a harbor, a graveyard, and things that should not be.
+Build dread slowly; suggest more than you show.
+
+Keep track of the player's location, the NPCs you've introduced (with consistent names),
+and ensure continuity as they walk, examine, or speak with people and creatures.
+When the player types commands like "go to…", "examine…", or "talk to X",
+respond with a vivid, immersive description or dialogue.
+`,
+}
+
+var (
+	themeName        = "fantasy"
+	systemPromptFile string
+	systemPrompt     = SYSTEM_PROMPT // world rules seeding new games
+)
+
+// resolveSystemPrompt picks the system prompt from -system-prompt-file or -theme
+func resolveSystemPrompt() error {
+	if systemPromptFile != "" {
+		b, err := ioutil.ReadFile(systemPromptFile)
+		if err != nil {
+			return err
+		}
+		systemPrompt, themeName = string(b), "custom"
+		return nil
+	}
+	p, ok := themes[strings.ToLower(themeName)]
+	if !ok {
+		var names []string
+		for n := range themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown theme %q (choose from %s)", themeName, strings.Join(names, ", "))
+	}
+	systemPrompt, themeName = p, strings.ToLower(themeName)
+	return nil
+}
+
 // Message for OpenAI chat API
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// Tool advertises a function the model may call
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction describes a callable function and its JSON-schema parameters
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolCall is a function call requested by the model
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
 }
 
 // ChatRequest payload
@@ -53,54 +158,266 @@ type ChatRequest struct {
 	Temperature float32   `json:"temperature,omitempty"`
 	TopP        float32   `json:"top_p,omitempty"`
 	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Tools       []Tool    `json:"tools,omitempty"`
 }
 
 // ChatResponse from OpenAI
 type ChatResponse struct {
 	Choices []struct {
-		Message Message `json:"message"`
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
 	} `json:"choices"`
 }
 
 // NPC data
 type Npc struct {
-	Bio       string `json:"bio"`
-	Backstory string `json:"backstory"`
-	Affinity  int    `json:"affinity"`
+	Bio       string         `json:"bio"`
+	Backstory string         `json:"backstory"`
+	Affinity  int            `json:"affinity"`
+	Kind      string         `json:"kind,omitempty"`      // "person" (the default) or "creature"
+	Observed  string         `json:"observed,omitempty"`  // how they looked when last examined
+	Gifts     map[string]int `json:"gifts,omitempty"`     // gifts received, counted by item category
+	LastSeen  int            `json:"last_seen,omitempty"` // game day they were last met or seen
+	Voice     string         `json:"voice,omitempty"`     // speaking style, kept across conversations
 }
 
 // Player state
 type PlayerState struct {
 	Stats            map[string]int             `json:"stats"`
-	Inventory        []string                   `json:"inventory"`
+	Inventory        Inventory                  `json:"inventory"`
 	Journal          []string                   `json:"journal"`
 	VisitedLocations []string                   `json:"visited_locations"`
 	MapGraph         map[string]map[string]bool `json:"map_graph"`
 	CurrentLocation  string                     `json:"current_location"`
+	SceneItems       map[string][]string        `json:"scene_items"`
+	Turn             int                        `json:"turn"`
+	Day              int                        `json:"day"`
+	Hour             int                        `json:"hour"`
+	Weather          string                     `json:"weather"`
+	Name             string                     `json:"name"`
+	Description      string                     `json:"description"`
+	Appearance       string                     `json:"appearance"`
+	Goals            []Goal                     `json:"goals"`
+	Class            string                     `json:"class"`
+	Reputation       map[string]int             `json:"reputation"`
+	Gold             int                        `json:"gold"`
+	Weights          map[string]int             `json:"weights"`
+	Capacity         int                        `json:"capacity"`
+	Categories       map[string]string          `json:"categories"`
+	Frontiers        map[string]map[string]bool `json:"frontiers"`
+	PathHistory      []string                   `json:"path_history"`
+	Spells           []string                   `json:"spells"`
+	Mana             int                        `json:"mana"`
+	MaxMana          int                        `json:"max_mana"`
+	Chapters         []string                   `json:"chapters"` // summaries of archived chapters
+	Difficulty       string                     `json:"difficulty"`
+	Aliases          map[string]string          `json:"aliases,omitempty"`    // this character's aliases, over the .advrc ones
+	StatNames        []string                   `json:"stat_names,omitempty"` // the attribute set, in display order
+	Effects          []Effect                   `json:"effects,omitempty"`    // lasting conditions such as poison
+	Checks           map[string]SkillCheck      `json:"checks,omitempty"`     // named checks, by lowercase name
+	HP               int                        `json:"hp"`
+	MaxHP            int                        `json:"max_hp"`
+	Bookmarks        map[string]string          `json:"bookmarks,omitempty"` // label -> location
+	Stamina          int                        `json:"stamina"`
+	MaxStamina       int                        `json:"max_stamina"`
+}
+
+// Goal is a player-authored reminder, not a model-driven quest
+type Goal struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// Effect is a lasting condition that changes HP each turn until it expires
+type Effect struct {
+	Name  string `json:"name"`
+	Delta int    `json:"delta"` // HP per turn: negative harms, positive heals
+	Turns int    `json:"turns"` // turns remaining
+}
+
+// SkillCheck is a named roll of one stat, with an optional default DC
+type SkillCheck struct {
+	Stat string `json:"stat"`
+	DC   int    `json:"dc,omitempty"`
 }
 
 // SaveData for save/load
 type SaveData struct {
-	NpcData     map[string]*Npc `json:"npc_data"`
-	PlayerState PlayerState     `json:"player_state"`
-	History     []Message       `json:"history"`
+	NpcData           map[string]*Npc   `json:"npc_data"`
+	PlayerState       PlayerState       `json:"player_state"`
+	History           []Message         `json:"history"`
+	SceneDescriptions map[string]string `json:"scene_descriptions"`
+	Theme             string            `json:"theme,omitempty"`
+	WorldPrompt       string            `json:"world_prompt,omitempty"`
+	AmbientLines      map[string]string `json:"ambient_lines"`
+	ItemsData         map[string]string `json:"items_data"`
+	SummaryPrompt     string            `json:"summary_prompt,omitempty"`
+	Seed              int64             `json:"seed,omitempty"`
+	RandDraws         uint64            `json:"rand_draws,omitempty"`
 }
 
 var (
 	globalAPIKey        string
+	globalOrg           string // OPENAI_ORG, sent as OpenAI-Organization when set
+	globalProject       string // OPENAI_PROJECT, sent as OpenAI-Project when set
+	apiURL              = "https://api.openai.com/v1/chat/completions"
+	httpClient          = &http.Client{} // swappable so the transport can be stubbed; each request sets its own timeout
+	retryDelay          = 1 * time.Second
+	maxRetries          = 2 // further attempts after a failed request
 	globalModel         string
 	pruneEnabled        = true
+	persistentScenes    = true
+	ambientEnabled      = true
+	debugMode           bool
+	capWarned           bool
+	pruneTokens         = 0
+	pruneTailTokens     = 2000
 	npcData             = map[string]*Npc{}
 	sceneDescriptions   = map[string]string{}
+	ambientLines        = map[string]string{} // one-line atmosphere per location
 	itemsData           = map[string]string{}
 	playerState         PlayerState
 	history             []Message
-	summaryPrompt       = "Summarize the following adventure context in two sentences."
-	placeholderResponse = "[The realm is silent; no response comes.]"
+	summaryPrompt       = "Summarize the following adventure context in two sentences." // set summary-prompt or -summary-prompt-file
+	summaryPromptFile   string
+	placeholderResponse = "[The realm is silent; no response comes.]" // network or API failure
+	emptyResponse       = "[The narrator falls silent, with nothing to add.]"
+	filteredResponse    = "[The narrator declines to describe that. Try rephrasing your action.]"
+	aliases             = map[string]string{}
+	lastCmd             string
+	lastNpcs            []string // NPCs from the most recent scene listing
+	lastNpcsLoc         string   // location lastNpcs was listed at
+	lastItems           []string // objects from the most recent scene listing
+	lastItemsLoc        string   // location lastItems was listed at
+	recapText           string   // cached recap of the story so far
+	recapTokens         int      // history size when recapText was written
+	input               = bufio.NewReader(os.Stdin)
+	logPath             string
+	dataDir             string // where saves, logs and .advrc live
+	transcriptFile      *os.File
+	replayPath          string
+	replayStopOnDiff    bool
+	scriptPath          string
+	scriptLines         []string   // scripted input still to be read
+	thenInteractive     bool       // after a -script runs out, read from the terminal
+	showDiff            bool       // regenerate prints the replaced narration too
+	regenReady          bool       // the last command ended with a narration
+	chatTemperature     float32    = 0.8
+	toolsEnabled        bool       // state changes arrive as tool calls rather than markers
+	startDifficulty     = "normal" // difficulty for new games, from -difficulty
+	rcSettings          = map[string]string{}
+	stateMu             sync.Mutex          // guards npcData, sceneDescriptions, itemsData, ambientLines, playerState and history
+	stateHeld           bool                // whether the main goroutine holds stateMu
+	talkingTo           = map[string]bool{} // NPCs in the conversation under way
+)
+
+// Opening scene used when the player doesn't choose one
+const defaultStart = "Year 1372, in the misty Isle of Everdawn"
+
+// The start scene from -start, or from .advrc's "start" with -quick-start
+var (
+	startScene string
+	startSet   bool
+	quickStart bool
+)
+
+// Save file names
+const (
+	saveFile  = "savegame.json"
+	crashFile = "crash-recovery.json"
+)
+
+// defaultDataDir is the OS-appropriate home for game data
+func defaultDataDir() string {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "windows":
+		if d := os.Getenv("APPDATA"); d != "" {
+			return filepath.Join(d, "adv")
+		}
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "adv")
+	default:
+		if d := os.Getenv("XDG_DATA_HOME"); d != "" {
+			return filepath.Join(d, "adv")
+		}
+	}
+	return filepath.Join(home, ".local", "share", "adv")
+}
+
+// setupDataDir creates the data directory, falling back to the working
+// directory if it can't be made
+func setupDataDir() {
+	if dataDir == "" {
+		dataDir = defaultDataDir()
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, "Data directory error, using the current directory:", err)
+		dataDir = "."
+	}
+}
+
+// dataPath resolves a file name inside the data directory; absolute paths
+// are left alone
+func dataPath(name string) string {
+	if filepath.IsAbs(name) || dataDir == "" {
+		return name
+	}
+	return filepath.Join(dataDir, name)
+}
+
+// rcFile holds command aliases ("alias x = examine") and other key=value settings
+const rcFile = ".advrc"
+
+// Aliases available even without a config file
+var defaultAliases = map[string]string{
+	"n": "north",
+	"s": "south",
+	"e": "east",
+	"w": "west",
+	"i": "inventory",
+	"l": "look",
+	"x": "examine",
+	"t": "talk to",
+}
+
+// countingSource counts draws from the random source so a loaded game can
+// pick up its sequence where the save left it
+type countingSource struct {
+	src   rand.Source
+	draws uint64
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+// rng is the single source for every roll; all randomness goes through it
+var (
+	rng      *rand.Rand
+	rngSrc   *countingSource
+	rngSeed  int64
+	seedFlag int64 // from -seed; 0 seeds from the clock
 )
 
+// seedRNG restarts rng from seed, skipping the draws already made
+func seedRNG(seed int64, draws uint64) {
+	rngSeed = seed
+	rngSrc = &countingSource{src: rand.NewSource(seed)}
+	rng = rand.New(rngSrc)
+	for range draws {
+		rngSrc.Int63()
+	}
+}
+
 func init() {
-	rand.Seed(time.Now().UnixNano())
+	seedRNG(time.Now().UnixNano(), 0)
 }
 
 // Normalize multiline text: remove CRs, trim blanks, collapse multiple blanks
@@ -150,579 +467,6143 @@ func contains(slice []string, s string) bool {
 	return false
 }
 
-// Call OpenAI API with retries
-func callOpenAI(msgs []Message) string {
-	req := ChatRequest{Model: globalModel, Messages: msgs, Temperature: 0.8, MaxTokens: 500, TopP: 0.9}
-	payload, err := json.Marshal(req)
+// loadRC seeds the default aliases and overlays any found in .advrc
+func loadRC() {
+	for k, v := range defaultAliases {
+		aliases[k] = v
+	}
+	b, err := ioutil.ReadFile(dataPath(rcFile))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "JSON marshal error:", err)
-		return placeholderResponse
+		return
 	}
-	for attempt := 0; attempt < 3; attempt++ {
-		httpReq, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(payload))
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Request error:", err)
-			return placeholderResponse
-		}
-		httpReq.Header.Set("Content-Type", "application/json")
-		httpReq.Header.Set("Authorization", "Bearer "+globalAPIKey)
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err := client.Do(httpReq)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "API error:", err)
-			time.Sleep(1 * time.Second)
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Read error:", err)
-			return placeholderResponse
-		}
-		if resp.StatusCode != http.StatusOK {
-			fmt.Fprintln(os.Stderr, "HTTP", resp.StatusCode, string(body))
-			time.Sleep(1 * time.Second)
+		i := strings.Index(line, "=")
+		if i < 0 {
 			continue
 		}
-		var res ChatResponse
-		if err := json.Unmarshal(body, &res); err != nil {
-			fmt.Fprintln(os.Stderr, "Unmarshal error:", err)
-			return placeholderResponse
-		}
-		if len(res.Choices) > 0 {
-			return strings.TrimSpace(res.Choices[0].Message.Content)
+		key, val := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if strings.HasPrefix(key, "alias ") {
+			aliases[strings.ToLower(strings.TrimSpace(key[6:]))] = val
+		} else {
+			rcSettings[key] = val
 		}
-		return placeholderResponse
 	}
-	fmt.Fprintln(os.Stderr, "[Error] Could not reach OpenAI API. Continuing with placeholder response.")
-	return placeholderResponse
 }
 
-// Prune and summarize history
-func pruneHistory(msgs []Message) []Message {
-	maxMsgs, keepTail := 30, 10
-	if len(msgs) <= maxMsgs {
-		return msgs
+// saveRC writes the current aliases and settings back to .advrc
+func saveRC() error {
+	var lines []string
+	keys := make([]string, 0, len(rcSettings))
+	for k := range rcSettings {
+		keys = append(keys, k)
 	}
-	toSumm := msgs[:len(msgs)-keepTail]
-	tail := msgs[len(msgs)-keepTail:]
-	prompt := []Message{{Role: "system", Content: summaryPrompt}}
-	prompt = append(prompt, toSumm...)
-	summary := callOpenAI(prompt)
-	newHist := []Message{{Role: "system", Content: "SUMMARY: " + summary}}
-	newHist = append(newHist, tail...)
-	fmt.Println(Yellow + "[History pruned and summarized]" + Reset)
-	return newHist
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s = %s", k, rcSettings[k]))
+	}
+	keys = keys[:0]
+	for k := range aliases {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("alias %s = %s", k, aliases[k]))
+	}
+	return ioutil.WriteFile(dataPath(rcFile), []byte(strings.Join(lines, "\n")+"\n"), 0644)
 }
 
-// List items in scene via AI
-func listItems(msgs []Message) []string {
-	prompt := append(msgs, Message{Role: "user", Content: "List, in a comma-separated list, all objects present in this scene. If none, reply 'None'."})
-	raw := callOpenAI(prompt)
-	parts := strings.Split(raw, ",")
-	var out []string
-	for _, p := range parts {
-		name := strings.Trim(strings.TrimSpace(p), ".!?:;")
-		if name != "" && strings.ToLower(name) != "none" {
-			out = append(out, name)
-		}
+// expandAlias replaces a leading alias word with the command it stands for;
+// the save's own aliases take precedence over the global ones
+func expandAlias(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return cmd
 	}
-	return out
+	word := strings.ToLower(fields[0])
+	exp, ok := playerState.Aliases[word]
+	if !ok {
+		exp, ok = aliases[word]
+	}
+	if !ok {
+		return cmd
+	}
+	rest := strings.TrimSpace(cmd[len(fields[0]):])
+	if rest == "" {
+		return exp
+	}
+	return exp + " " + rest
 }
 
-// List exits via AI
-func listExits(msgs []Message) []string {
-	prompt := append(msgs, Message{Role: "user", Content: "List, in a comma-separated list, all exits or directions available from this scene. If none, reply 'None'."})
-	raw := callOpenAI(prompt)
-	parts := strings.Split(raw, ",")
-	var out []string
-	for _, p := range parts {
-		name := strings.Trim(strings.TrimSpace(p), ".!?:;")
-		if name != "" && strings.ToLower(name) != "none" {
-			out = append(out, name)
-		}
+// readLine reads one trimmed line of player input, taking it from the
+// -script file first
+func readLine() (string, error) {
+	if len(scriptLines) > 0 {
+		line := scriptLines[0]
+		scriptLines = scriptLines[1:]
+		fmt.Println(line)
+		return line, nil
 	}
-	return out
+	if scriptPath != "" && !thenInteractive {
+		return "", io.EOF
+	}
+	// waiting for input is a safe point for the shutdown handler
+	if held := stateHeld; held {
+		unlockState()
+		defer lockState()
+	}
+	line, err := input.ReadString('\n')
+	return strings.TrimSpace(line), err
 }
 
-// List NPCs via AI
-func listNpcs(msgs []Message) []string {
-	prompt := append(msgs, Message{Role: "user", Content: "List, in a comma-separated list, the FULL NAMES of all NPCs currently present in this scene. If none, reply 'None'."})
-	raw := callOpenAI(prompt)
-	parts := strings.Split(raw, ",")
-	var out []string
-	for _, p := range parts {
-		name := strings.Trim(strings.TrimSpace(p), ".!?:;")
-		if name != "" && strings.ToLower(name) != "none" {
-			out = append(out, name)
+// loadScript queues a script's lines as player input, skipping # comments;
+// blank lines are kept since they answer prompts like the name question
+func loadScript(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(string(b), "\r", ""), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
 		}
+		scriptLines = append(scriptLines, strings.TrimSpace(line))
 	}
-	return out
+	// a trailing newline leaves one empty line that was never typed
+	if n := len(scriptLines); n > 0 && scriptLines[n-1] == "" {
+		scriptLines = scriptLines[:n-1]
+	}
+	return nil
 }
 
-// Print environment summary (exits, NPCs, items)
-func printEnvironmentSummary(msgs []Message) {
-	exits := listExits(msgs)
-	npcs := listNpcs(msgs)
-	items := listItems(msgs)
-	fmt.Printf(Blue+"Exits:"+Reset+" %s\n", strings.Join(exits, ", "))
-	fmt.Printf(Green+"NPCs here:"+Reset+" %s\n", strings.Join(npcs, ", "))
-	fmt.Printf(Yellow+"Items here:"+Reset+" %s\n", strings.Join(items, ", "))
+// Output settings: -no-color drops the ANSI codes and, like output that
+// isn't a terminal, turns paging off; -pager hands long output to $PAGER
+var (
+	noColor  bool
+	usePager bool
+)
+
+// disableColor blanks the color codes for -no-color
+func disableColor() {
+	Red, Green, Yellow, Blue, Magenta, Dim, Reset = "", "", "", "", "", "", ""
+	speakerColors = []string{"", "", "", ""}
 }
 
-// Initialize new player state
-func initPlayerState() {
-	stats := map[string]int{}
-	for _, s := range []string{"STR", "DEX", "CON", "INT", "WIS", "CHA"} {
-		stats[s] = rand.Intn(11) + 8
+// colorWriter writes streamed text in one color: the code goes out before
+// the first chunk and Reset on Close, and escape sequences in the text
+// itself are dropped, even when split across chunks
+type colorWriter struct {
+	w       io.Writer
+	color   string
+	started bool
+	esc     int // 1 after ESC, 2 inside a CSI sequence
+}
+
+func newColorWriter(w io.Writer, color string) *colorWriter {
+	return &colorWriter{w: w, color: color}
+}
+
+func (cw *colorWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+len(cw.color))
+	if !cw.started {
+		out = append(out, cw.color...)
+		cw.started = true
 	}
-	playerState = PlayerState{
-		Stats:            stats,
-		Inventory:        []string{},
-		Journal:          []string{},
-		VisitedLocations: []string{},
-		MapGraph:         map[string]map[string]bool{},
-		CurrentLocation:  "",
+	for _, b := range p {
+		switch {
+		case cw.esc == 0 && b == 0x1b:
+			cw.esc = 1
+		case cw.esc == 1 && b == '[':
+			cw.esc = 2
+		case cw.esc == 1:
+			cw.esc = 0 // a two-byte escape
+		case cw.esc == 2:
+			// a CSI sequence ends with a byte in @ through ~
+			if b >= 0x40 && b <= 0x7e {
+				cw.esc = 0
+			}
+		default:
+			out = append(out, b)
+		}
+	}
+	if _, err := cw.w.Write(out); err != nil {
+		return 0, err
 	}
+	return len(p), nil
 }
 
-// Save game to JSON file
-func saveGame(msgs []Message) {
-	d := SaveData{NpcData: npcData, PlayerState: playerState, History: msgs}
-	b, err := json.MarshalIndent(d, "", "  ")
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Save encode error:", err)
-		return
+// Close ends the colored run, if anything was written
+func (cw *colorWriter) Close() error {
+	cw.esc = 0
+	if !cw.started {
+		return nil
 	}
-	if err := ioutil.WriteFile("savegame.json", b, 0644); err != nil {
-		fmt.Fprintln(os.Stderr, "Save file error:", err)
-		return
+	_, err := io.WriteString(cw.w, Reset)
+	return err
+}
+
+// Title banners by theme; -banner-file replaces them
+var banners = map[string]string{
+	"fantasy": `        /\                                      /\
+       /  \     R E A L M W E A V E R          /  \
+      /_/\_\  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~  /_/\_\
+      |[] |     tales of roads, ruins and     |[] |
+      |   |        the people between them    |   |
+    __|___|__________________________________|___|__`,
+	"cyberpunk": `   ___  _   _ ___ ___  ___ _    ___   ___ _  __
+  / _ \| | | | __| _ \/ __| |  / _ \ / __| |/ /
+ | (_) | |_| | _||   / (__| |_| (_) | (__| ' <
+  \___/ \___/|___|_|_\\___|____\___/ \___|_|\_\
+  >> jack in. the city never logs off. _`,
+	"horror": `      .-.           T H E   K E E P E R          .-.
+     (o o)   ~ the door is open. the lamp is low. (o o)
+     | O \                                       / O |
+      \   \        something is listening.      /   /
+       '~~~'                                   '~~~'`,
+}
+
+var bannerFile string
+
+// printBanner shows the theme's title art above the welcome line, or just
+// the welcome line when there is none or the terminal is too narrow for it
+func printBanner() {
+	art := banners[themeName]
+	if bannerFile != "" {
+		b, err := ioutil.ReadFile(bannerFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Banner file error:", err)
+		} else {
+			art = strings.Trim(strings.ReplaceAll(string(b), "\r", ""), "\n")
+		}
+	}
+	fits := art != ""
+	for _, line := range strings.Split(art, "\n") {
+		if utf8.RuneCountInString(line) > termWidth() {
+			fits = false
+		}
+	}
+	if fits {
+		fmt.Println(Magenta + art + Reset)
+		fmt.Println()
 	}
-	fmt.Printf(Yellow + "Game saved to savegame.json." + Reset + "\n")
+	fmt.Print(Blue + tr("Welcome to the Immersive Text Adventure!") + Reset + "\n")
 }
 
-// Load game from JSON file
-func loadGame() ([]Message, error) {
-	b, err := ioutil.ReadFile("savegame.json")
-	if err != nil {
-		return nil, err
+// termWidth returns the terminal's columns from $COLUMNS, or 80
+func termWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
 	}
-	var d SaveData
-	if err := json.Unmarshal(b, &d); err != nil {
-		return nil, err
+	return 80
+}
+
+// termHeight returns the terminal's rows from $LINES, or 24
+func termHeight() int {
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 2 {
+		return n
 	}
-	npcData = d.NpcData
-	playerState = d.PlayerState
-	fmt.Printf(Yellow + "Game loaded from savegame.json." + Reset + "\n")
-	return d.History, nil
+	return 24
 }
 
-// Start conversation with NPC
-func startConversation(npcName string) {
-	if _, ok := npcData[npcName]; !ok {
-		last := history
-		if len(last) > 6 {
-			last = last[len(last)-6:]
-		}
-		prompt := append(last, Message{Role: "user", Content: fmt.Sprintf(
-			"You previously described an NPC named '%s'.\n"+
-				"Please provide TWO clearly labeled sections:\n"+
-				"BIO: One sentence describing who they are (name/title/role).\n"+
-				"BACKSTORY: Two sentences about their past, interests, or beliefs.\n"+
-				"Respond exactly in this format.", npcName)})
-		summary := callOpenAI(prompt)
-		bio, backstory := "", ""
-		for _, line := range strings.Split(summary, "\n") {
-			up := strings.ToUpper(line)
-			if strings.HasPrefix(up, "BIO:") {
-				bio = strings.TrimSpace(line[4:])
-			}
-			if strings.HasPrefix(up, "BACKSTORY:") {
-				backstory = strings.TrimSpace(line[9:])
-			}
-		}
-		if bio == "" {
-			bio = fmt.Sprintf("%s, a person of note.", npcName)
-		}
-		if backstory == "" {
-			backstory = "They prefer to keep much of their past private."
-		}
-		npcData[npcName] = &Npc{Bio: bio, Backstory: backstory, Affinity: 0}
+// pagingEnabled reports whether long output should be paged: only on an
+// interactive terminal with color on
+func pagingEnabled() bool {
+	if noColor || scriptPath != "" || replayPath != "" {
+		return false
 	}
-	info := npcData[npcName]
-	sys := fmt.Sprintf("You are %s.\n%s\nBackstory: %s\n\n"+
-		"Speak in first-person as yourself. ALWAYS refer to yourself by that exact name. "+
-		"When the player says 'goodbye', 'exit', or 'bye', end the conversation politely.",
-		npcName, info.Bio, info.Backstory)
-	conv := []Message{{Role: "system", Content: sys}}
-	fmt.Printf("\n"+Blue+"— You begin talking with %s. (type 'goodbye' to end) —"+Reset+"\n\n", npcName)
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Print("You: ")
-		line, _ := reader.ReadString('\n')
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		conv = append(conv, Message{Role: "user", Content: line})
-		low := strings.ToLower(line)
-		if low == "goodbye" || low == "exit" || low == "bye" {
-			farewell := callOpenAI(conv)
-			fmt.Printf(Green+"%s:"+Reset+" %s\n\n", npcName, farewell)
-			info.Affinity++
-			fmt.Println("— Conversation ended. You return to exploration. —\n")
-			return
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		if fi, err := f.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return false
 		}
-		reply := callOpenAI(conv)
-		fmt.Printf(Green+"%s:"+Reset+" %s\n", npcName, reply)
-		conv = append(conv, Message{Role: "assistant", Content: reply})
 	}
+	return true
 }
 
-// Draw ASCII map recursively
-func drawMap(node, parent, prefix string, isLast bool, visited map[string]bool) {
-	if visited == nil {
-		visited = map[string]bool{node: true}
-		fmt.Println(prefix + node)
-	} else {
-		branch := "├─ "
-		if isLast {
-			branch = "└─ "
-		}
-		fmt.Println(prefix + branch + node)
-		visited[node] = true
+// paged runs fn, showing what it prints a screen at a time when paging is on
+func paged(fn func()) {
+	if !pagingEnabled() {
+		fn()
+		return
 	}
-	neighbors := make([]string, 0)
-	for n := range playerState.MapGraph[node] {
-		neighbors = append(neighbors, n)
+	r, w, err := os.Pipe()
+	if err != nil {
+		fn()
+		return
 	}
-	sort.Strings(neighbors)
-	children := make([]string, 0)
-	for _, c := range neighbors {
-		if c == parent || visited[c] {
-			continue
-		}
-		children = append(children, c)
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	fn()
+	w.Close()
+	os.Stdout = stdout
+	text := <-out
+	r.Close()
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if usePager && runPager(text) {
+		return
 	}
-	for i, child := range children {
-		last := i == len(children)-1
-		nextPrefix := prefix
-		if isLast {
-			nextPrefix += "   "
-		} else {
-			nextPrefix += "│  "
+	page := termHeight() - 1
+	for i, line := range lines {
+		if i > 0 && i%page == 0 {
+			fmt.Print(Dim + "-- more -- (Enter to continue, q to stop)" + Reset)
+			ans, err := readLine()
+			// clear the prompt line so the pages join up
+			fmt.Print("\033[1A\033[2K")
+			if err != nil || strings.EqualFold(ans, "q") {
+				return
+			}
 		}
-		drawMap(child, node, nextPrefix, last, visited)
+		fmt.Println(line)
 	}
 }
 
-// printHelp displays the list of available commands
-func printHelp() {
-	fmt.Println()
-	fmt.Println("Available commands:")
-	fmt.Println("  go to/move to/travel to <location>    - Move to a place or direction")
-	fmt.Println("  north/south/east/west                 - Move in a cardinal direction")
-	fmt.Println("  look / observe / where                - Describe your surroundings")
-	fmt.Println("  examine <object> / look at <object> / inspect <object> - Inspect something")
-	fmt.Println("  talk to                              - List NPCs here")
-	fmt.Println("  talk to <NPC name>                   - Start conversation with someone")
-	fmt.Println("  inventory                            - Show your items")
-	fmt.Println("  stats                                - Show your character stats")
-	fmt.Println("  journal                              - Show your journal entries")
-	fmt.Println("  save                                 - Save your current game")
-	fmt.Println("  load                                 - Load a saved game")
-	fmt.Println("  map [<location>]                     - Show ASCII map (default=current loc)")
-	fmt.Println("  hint                                 - Get an in-game hint")
-	fmt.Println("  set prune on|off                     - Enable/disable history summarization")
-	fmt.Println("  roll <STAT> [DC]                     - Perform a d20 skill/attribute check")
-	fmt.Println("  help / ?                             - Show this help text")
-	fmt.Println("  quit / exit / stop                   - End the adventure or exit NPC chat")
-	fmt.Println()
+// runPager pipes text through $PAGER (or more), reporting whether it ran
+func runPager(text string) bool {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "more"
+	}
+	args := strings.Fields(pager)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "Pager error:", err)
+		return false
+	}
+	return true
 }
 
-func main() {
-	globalAPIKey = os.Getenv("OPENAI_API_KEY")
-	if globalAPIKey == "" {
-		fmt.Fprintln(os.Stderr, Red+"OPENAI_API_KEY not set"+Reset)
-		os.Exit(1)
+// readReply reads a line answering the game mid-command, such as a
+// conversation line, and records it in the transcript
+func readReply() (string, error) {
+	line, err := readLine()
+	if err == nil || line != "" {
+		logTranscript(TranscriptEntry{Kind: "reply", Input: line})
 	}
-	globalModel = "gpt-4.1-mini"
-	reader := bufio.NewReader(os.Stdin)
+	return line, err
+}
 
-	// Main menu
-	fmt.Printf(Blue + "Welcome to the Immersive Text Adventure!" + Reset + "\n")
-	fmt.Printf("1) New game  2) Load game  3) Quit\n> ")
-	choice, _ := reader.ReadString('\n')
-	choice = strings.TrimSpace(choice)
-	var loaded []Message
-	if choice == "2" {
-		h, err := loadGame()
-		if err != nil {
-			fmt.Printf(Red + "No save file found." + Reset + "\n")
-			initPlayerState()
-		} else {
-			loaded = h
-			history = h
-			if len(history) > 0 && history[len(history)-1].Role == "assistant" {
-				fmt.Println(Blue + history[len(history)-1].Content + Reset)
+// debugPrompt prints the messages about to be sent, and which helper sent them, to stderr
+func debugPrompt(msgs []Message) {
+	caller := "unknown"
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		caller = strings.TrimPrefix(runtime.FuncForPC(pc).Name(), "main.")
+	}
+	fmt.Fprintf(os.Stderr, Dim+"[debug] %s → %s, %d messages (~%d tokens)"+Reset+"\n", caller, globalModel, len(msgs), historyTokens(msgs))
+	for i, m := range msgs {
+		content := strings.ReplaceAll(m.Content, "\n", " ⏎ ")
+		if r := []rune(content); len(r) > 160 {
+			content = string(r[:160]) + "…"
+		}
+		fmt.Fprintf(os.Stderr, Dim+"  %3d %-9s %s"+Reset+"\n", i, m.Role, content)
+	}
+}
+
+// historyCmd lists the raw history, shows one message in full or deletes
+// one; only available with debugging on
+func historyCmd(arg string) {
+	if !debugMode {
+		fmt.Println("history is a debugging command; start with -debug or use 'set debug on'.")
+		return
+	}
+	f := strings.Fields(strings.ToLower(arg))
+	if len(f) == 0 {
+		fmt.Printf(Blue+"History: %d messages (~%d tokens)"+Reset+"\n", len(history), historyTokens(history))
+		for i, m := range history {
+			content := strings.ReplaceAll(m.Content, "\n", " ⏎ ")
+			if r := []rune(content); len(r) > 100 {
+				content = string(r[:100]) + "…"
 			}
+			fmt.Printf("%3d %-9s %s\n", i, m.Role, content)
 		}
-	} else if choice == "3" {
-		fmt.Println(Yellow + "Goodbye!" + Reset)
 		return
 	}
-	if len(loaded) == 0 {
-		initPlayerState()
-		fmt.Println("First, choose when and where your story begins (e.g. Year 1372, Isle of Everdawn)")
-		fmt.Print("> ")
-		start, _ := reader.ReadString('\n')
-		start = strings.TrimSpace(start)
-		if start == "" {
-			start = "Year 1372, in the misty Isle of Everdawn"
+	if len(f) != 2 || (f[0] != "show" && f[0] != "delete") {
+		fmt.Println("Usage: history [show <n> | delete <n>]")
+		return
+	}
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n < 0 || n >= len(history) {
+		fmt.Printf(Red+"No message %s; history has %d (0-%d)."+Reset+"\n", f[1], len(history), len(history)-1)
+		return
+	}
+	if f[0] == "show" {
+		fmt.Printf(Blue+"%d %s:"+Reset+"\n%s\n", n, history[n].Role, history[n].Content)
+		return
+	}
+	if history[n].Role == "system" {
+		fmt.Println(Red + "The system prompt can't be deleted." + Reset)
+		return
+	}
+	history = slices.Delete(history, n, n+1)
+	fmt.Printf(Yellow+"Deleted message %d; history now has %d messages."+Reset+"\n", n, len(history))
+}
+
+// Request timeouts: short for lists, one-word answers and summaries, which
+// can be abandoned quickly, and longer for narration; -timeout sets both
+var (
+	quickTimeout     = 15 * time.Second
+	narrationTimeout = 60 * time.Second
+	requestTimeout   time.Duration
+)
+
+// Call OpenAI API with retries
+func callOpenAI(msgs []Message) string {
+	return callOpenAIWithin(msgs, narrationTimeout)
+}
+
+// callOpenAIQuick is callOpenAI for list and summary calls
+func callOpenAIQuick(msgs []Message) string {
+	return callOpenAIWithin(msgs, quickTimeout)
+}
+
+// callOpenAIWithin calls the API, giving each request at most timeout
+func callOpenAIWithin(msgs []Message, timeout time.Duration) string {
+	text, _ := chatWithin(msgs, timeout)
+	return text
+}
+
+// callOpenAIKeep is callOpenAI for callers that keep the messages they send,
+// such as conversations: it also returns msgs, shortened if they had to be
+// summarized to fit the model
+func callOpenAIKeep(msgs []Message) (string, []Message) {
+	return chatWithin(msgs, narrationTimeout)
+}
+
+// callOpenAIOnHistory narrates from history and the world context, keeping
+// history shortened if it had to be summarized to fit the model
+func callOpenAIOnHistory() string {
+	text, sent := callOpenAIKeep(withWorldContext(history))
+	if n := len(sent); n > 0 && n < len(history)+1 {
+		history = sent[:n-1]
+	}
+	return text
+}
+
+// chatWithin does the work of callOpenAIWithin, returning the messages
+// actually sent along with the reply
+func chatWithin(msgs []Message, timeout time.Duration) (string, []Message) {
+	if debugMode {
+		debugPrompt(msgs)
+	}
+	reply, reason, msgs, err := sendChat(msgs, nil, timeout)
+	lastCallErr = err
+	return continueReply(msgs, reply.Content, reason, timeout), msgs
+}
+
+// sendChat is requestChat for narration and dialogue, with or without tools.
+// A blank reply is usually a fluke, so it asks once more, and a request too
+// long for the model is retried with its older part summarized. It returns
+// the messages it ended up sending.
+func sendChat(msgs []Message, tools []Tool, timeout time.Duration) (Message, string, []Message, error) {
+	reply, reason, err := requestChat(msgs, tools, timeout)
+	if err == nil && reply.Content == emptyResponse {
+		reply, reason, err = requestChat(msgs, tools, timeout)
+	}
+	if errors.Is(err, ErrContextLength) && !shortening {
+		msgs = shortenForRetry(msgs)
+		reply, reason, err = requestChat(msgs, tools, timeout)
+		if errors.Is(err, ErrContextLength) {
+			fmt.Println(Red + "Even summarized, the story is too long for the model. Try 'chapter end' to archive it." + Reset)
 		}
-		fmt.Println()
-		fmt.Println(Blue + "…Very well. Setting the scene…" + Reset + "\n")
-		history = []Message{{Role: "system", Content: SYSTEM_PROMPT}, {Role: "user", Content: "Begin the adventure: " + start}}
-		intro := normalizeText(callOpenAI(history))
-		fmt.Println(Blue + intro + Reset)
-		history = append(history, Message{Role: "assistant", Content: intro})
-		playerState.CurrentLocation = start
-		sceneDescriptions[start] = intro
-		playerState.VisitedLocations = append(playerState.VisitedLocations, start)
-		printHelp()
 	}
+	return reply, reason, msgs, err
+}
 
-	// Game loop
-	for {
-		loc := playerState.CurrentLocation
-		if loc != "" {
-			fmt.Printf("%s> ", loc)
-		} else {
-			fmt.Print("> ")
+// continueReply asks a few times for the rest of a reply to msgs that the
+// token limit cut off
+func continueReply(msgs []Message, text, reason string, timeout time.Duration) string {
+	for i := 0; reason == "length" && i < maxContinuations; i++ {
+		more := append(append([]Message{}, msgs...),
+			Message{Role: "assistant", Content: text},
+			Message{Role: "user", Content: "Continue exactly where you left off, without repeating anything."})
+		var next Message
+		next, reason, _ = requestChat(more, nil, timeout)
+		cont := next.Content
+		if isDegraded(cont) {
+			break
 		}
-		cmd, _ := reader.ReadString('\n')
-		cmd = strings.TrimSpace(cmd)
-		if cmd == "" {
-			continue
+		text = normalizeText(joinContinuation(text, cont))
+	}
+	return text
+}
+
+// shortening is set while recovering from an over-long request, so the
+// summary calls it makes are not themselves retried the same way
+var shortening bool
+
+// shortenForRetry recovers from a request too long for the model by
+// summarizing the older half of msgs, even with pruning off
+func shortenForRetry(msgs []Message) []Message {
+	shortening = true
+	defer func() { shortening = false }()
+	fmt.Println(Yellow + "[Too much to send at once; summarizing the older part and trying again]" + Reset)
+	n := historyTokens(msgs)
+	return pruneMessages(msgs, n/2, min(pruneTailTokens, n/4))
+}
+
+// maxContinuations caps follow-up requests for a truncated reply
+const maxContinuations = 2
+
+// joinContinuation appends continued text, adding a space unless the
+// continuation carries on with punctuation
+func joinContinuation(text, cont string) string {
+	text, cont = strings.TrimRight(text, " "), strings.TrimSpace(cont)
+	if cont == "" || strings.ContainsAny(cont[:1], ".,;:!?)'\"") || strings.HasSuffix(text, "\n") {
+		return text + cont
+	}
+	return text + " " + cont
+}
+
+// Client errors, distinguishable with errors.Is
+var (
+	ErrRateLimited   = errors.New("rate limited")
+	ErrAuth          = errors.New("authentication failed")
+	ErrServer        = errors.New("server error")
+	ErrNetwork       = errors.New("network error")
+	ErrContextLength = errors.New("context length exceeded")
+)
+
+// lastCallErr is the error from the latest callOpenAI, nil if it succeeded
+var lastCallErr error
+
+// statusError maps a failed HTTP status to one of the client errors
+func statusError(code int, body []byte) error {
+	var kind error
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		kind = ErrAuth
+	case code == http.StatusTooManyRequests:
+		kind = ErrRateLimited
+	case code >= 500:
+		kind = ErrServer
+	case code == http.StatusBadRequest && bytes.Contains(body, []byte("context_length_exceeded")):
+		kind = ErrContextLength
+	default:
+		return fmt.Errorf("HTTP %d: %s", code, strings.TrimSpace(string(body)))
+	}
+	return fmt.Errorf("%w (HTTP %d): %s", kind, code, strings.TrimSpace(string(body)))
+}
+
+// requestChat sends one chat request, advertising tools if given, and
+// returns the reply and the model's finish reason. Each attempt is given
+// timeout. Failures come back as a reply holding one of the degradation
+// messages, along with the error behind them.
+func requestChat(msgs []Message, tools []Tool, timeout time.Duration) (Message, string, error) {
+	req := ChatRequest{Model: globalModel, Messages: msgs, Temperature: chatTemperature, MaxTokens: 500, TopP: 0.9, Tools: tools}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "JSON marshal error:", err)
+		return Message{Role: "assistant", Content: placeholderResponse}, "", err
+	}
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(os.Stderr, Dim+"retrying (%d/%d)…"+Reset+"\n", attempt, maxRetries)
 		}
-		lc := strings.ToLower(cmd)
-		// toggle prune
-		if strings.HasPrefix(lc, "set prune") {
-			parts := strings.Fields(lc)
-			if len(parts) == 3 && (parts[2] == "on" || parts[2] == "off") {
-				pruneEnabled = (parts[2] == "on")
-				state := "disabled"
-				if pruneEnabled {
-					state = "enabled"
-				}
-				fmt.Printf("History summarization %s.\n", state)
-			} else {
-				fmt.Println("Usage: set prune on|off")
-			}
-			continue
+		ctx, cancel := context.WithTimeout(requestCtx, timeout)
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(payload))
+		if err != nil {
+			cancel()
+			fmt.Fprintln(os.Stderr, "Request error:", err)
+			return Message{Role: "assistant", Content: placeholderResponse}, "", err
 		}
-		if pruneEnabled {
-			history = pruneHistory(history)
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+globalAPIKey)
+		if globalOrg != "" {
+			httpReq.Header.Set("OpenAI-Organization", globalOrg)
 		}
-		// exit
-		switch lc {
-		case "quit", "exit", "stop":
-			fmt.Println(Yellow + "Farewell, traveler!" + Reset)
-			return
-		case "help", "?":
-			printHelp()
-			continue
-		case "inventory":
-			inv := "Empty"
-			if len(playerState.Inventory) > 0 {
-				inv = strings.Join(playerState.Inventory, ", ")
-			}
-			fmt.Printf(Yellow+"Inventory:"+Reset+" %s\n", inv)
-			continue
-		case "stats":
-			for k, v := range playerState.Stats {
-				fmt.Printf(" %s: %d\n", k, v)
-			}
-			continue
-		case "journal":
-			fmt.Println(Blue + "Journal Entries:" + Reset)
-			for _, e := range playerState.Journal {
-				fmt.Printf(" - %s\n", e)
-			}
-			continue
-		case "save":
-			saveGame(history)
-			continue
-		case "load":
-			if h, err := loadGame(); err == nil {
-				history = h
-			}
-			continue
-		case "hint":
-			hintPrompt := append(history, Message{Role: "user", Content: fmt.Sprintf("I'm stuck at %s. Please give me a hint.", playerState.CurrentLocation)})
-			hint := normalizeText(callOpenAI(hintPrompt))
-			fmt.Printf(Yellow+"Hint:"+Reset+" %s\n", hint)
-			continue
+		if globalProject != "" {
+			httpReq.Header.Set("OpenAI-Project", globalProject)
 		}
-		// roll
-		if strings.HasPrefix(lc, "roll") {
-			parts := strings.Fields(cmd)
-			if len(parts) >= 2 {
-				stat := strings.ToUpper(parts[1])
-				if val, ok := playerState.Stats[stat]; ok {
-					mod := (val - 10) / 2
-					die := rand.Intn(20) + 1
-					total := die + mod
-					result := fmt.Sprintf("Rolled 1d20 + %d = %d", mod, total)
-					if len(parts) >= 3 {
-						if dc, err := strconv.Atoi(parts[2]); err == nil {
-							outcome := "Failure"
-							if total >= dc {
-								outcome = "Success"
-							}
-							result += fmt.Sprintf(" vs DC %d: %s", dc, outcome)
-						}
-					}
-					fmt.Println(Yellow + result + Reset)
-				} else {
-					fmt.Printf(Red+"Unknown stat '%s'."+Reset+"\n", stat)
-				}
-			} else {
-				fmt.Println("Usage: roll <stat> [DC]")
+		resp, err := httpClient.Do(httpReq)
+		if err != nil {
+			cancel()
+			fmt.Fprintln(os.Stderr, "API error:", err)
+			lastErr = fmt.Errorf("%w: %v", ErrNetwork, err)
+			if !waitRetry(retryDelay) {
+				return Message{Role: "assistant", Content: placeholderResponse}, "", lastErr
 			}
 			continue
 		}
-		// map
-		if strings.HasPrefix(lc, "map") {
-			parts := strings.Fields(cmd)
-			target := playerState.CurrentLocation
-			if len(parts) > 1 {
-				target = titleCase(parts[1])
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Read error:", err)
+			return Message{Role: "assistant", Content: placeholderResponse}, "", fmt.Errorf("%w: %v", ErrNetwork, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Fprintln(os.Stderr, "HTTP", resp.StatusCode, string(body))
+			lastErr = statusError(resp.StatusCode, body)
+			if errors.Is(lastErr, ErrAuth) || errors.Is(lastErr, ErrContextLength) {
+				// retrying with the same key or the same messages cannot help
+				return Message{Role: "assistant", Content: placeholderResponse}, "", lastErr
 			}
-			fmt.Printf(Blue+"Map for '%s':"+Reset+"\n", target)
-			if len(playerState.VisitedLocations) > 0 {
-				fmt.Printf(Yellow+"Visited:"+Reset+" %s\n", strings.Join(playerState.VisitedLocations, ", "))
-			} else {
-				fmt.Printf(Yellow + "No visited locations yet." + Reset + "\n")
-			}
-			if _, ok := playerState.MapGraph[target]; !ok {
-				fmt.Printf(Yellow+"No map connections for '%s'."+Reset+"\n", target)
-				if desc, ex := sceneDescriptions[target]; ex {
-					fmt.Printf("\n"+Green+"Details for '%s':"+Reset+"\n", target)
-					for _, line := range strings.Split(desc, "\n") {
-						fmt.Printf("  %s\n", line)
-					}
+			delay := retryDelay
+			if errors.Is(lastErr, ErrRateLimited) {
+				// back off harder, as long as the server asks
+				delay = retryDelay * time.Duration(2<<attempt)
+				if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+					delay = time.Duration(secs) * time.Second
 				}
-				continue
 			}
-			drawMap(target, "", "", true, nil)
-			if desc, ex := sceneDescriptions[target]; ex {
-				fmt.Printf("\n"+Green+"Details for '%s':"+Reset+"\n", target)
-				for _, line := range strings.Split(desc, "\n") {
-					fmt.Printf("  %s\n", line)
-				}
+			if !waitRetry(delay) {
+				return Message{Role: "assistant", Content: placeholderResponse}, "", lastErr
 			}
 			continue
 		}
-		// talk to (list)
-		if lc == "talk to" {
-			npcs := listNpcs(history)
-			if len(npcs) == 0 {
-				fmt.Println(Yellow + "There's no one here to talk to." + Reset)
-			} else {
-				fmt.Printf(Green+"You can talk to:"+Reset+" %s\n", strings.Join(npcs, ", "))
+		var res ChatResponse
+		if err := json.Unmarshal(body, &res); err != nil {
+			fmt.Fprintln(os.Stderr, "Unmarshal error:", err)
+			return Message{Role: "assistant", Content: placeholderResponse}, "", err
+		}
+		if len(res.Choices) == 0 {
+			return Message{Role: "assistant", Content: emptyResponse}, "", nil
+		}
+		if res.Choices[0].FinishReason == "content_filter" {
+			return Message{Role: "assistant", Content: filteredResponse}, "", nil
+		}
+		reply := res.Choices[0].Message
+		reply.Content = strings.TrimSpace(reply.Content)
+		if reply.Content != "" || len(reply.ToolCalls) > 0 {
+			return reply, res.Choices[0].FinishReason, nil
+		}
+		return Message{Role: "assistant", Content: emptyResponse}, "", nil
+	}
+	fmt.Fprintln(os.Stderr, Red+"[Error] Could not reach the OpenAI API after "+strconv.Itoa(maxRetries+1)+" attempts. "+retryAdvice(lastErr)+Reset)
+	return Message{Role: "assistant", Content: placeholderResponse}, "", lastErr
+}
+
+// requestCtx is cancelled on shutdown, so a request in flight can't keep
+// main holding stateMu
+var requestCtx, cancelRequests = context.WithCancel(context.Background())
+
+// waitRetry pauses d before another attempt, reporting false once requests
+// have been cancelled for shutdown
+func waitRetry(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-requestCtx.Done():
+	}
+	return requestCtx.Err() == nil
+}
+
+// retryAdvice suggests what to do once every attempt at a request failed
+func retryAdvice(err error) string {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return "The API is rate limiting this key; wait a minute, or check your plan's limits."
+	case errors.Is(err, ErrServer):
+		return "The API is having trouble; try again shortly."
+	case errors.Is(err, ErrNetwork):
+		return "Check your internet connection; on a flaky network, raise -retries or -timeout."
+	}
+	return "Check your connection and OPENAI_API_KEY."
+}
+
+// summaryPrefix marks the rolling summary message in history
+const summaryPrefix = "SUMMARY: "
+
+// isSummary reports whether m is the rolling summary message
+func isSummary(m Message) bool {
+	return m.Role == "system" && strings.HasPrefix(m.Content, summaryPrefix)
+}
+
+// Approximate context window sizes in tokens, by model
+var contextWindows = map[string]int{
+	"gpt-4.1":       1047576,
+	"gpt-4.1-mini":  1047576,
+	"gpt-4.1-nano":  1047576,
+	"gpt-4o":        128000,
+	"gpt-4o-mini":   128000,
+	"gpt-4-turbo":   128000,
+	"gpt-4":         8192,
+	"gpt-3.5-turbo": 16385,
+}
+
+// estimateTokens approximates a message's token count at four characters per token
+func estimateTokens(m Message) int {
+	return len(m.Content)/4 + 4
+}
+
+// historyTokens approximates the token count of a message list
+func historyTokens(msgs []Message) int {
+	total := 0
+	for _, m := range msgs {
+		total += estimateTokens(m)
+	}
+	return total
+}
+
+// contextWindow returns the context size of the current model
+func contextWindow() int {
+	if n, ok := contextWindows[globalModel]; ok {
+		return n
+	}
+	return 128000
+}
+
+// historyBudget returns the token count above which history is summarized;
+// by default a quarter of the context window, capped to keep each turn cheap
+func historyBudget() int {
+	if pruneTokens > 0 {
+		return pruneTokens
+	}
+	budget := contextWindow() / 4
+	if budget > 24000 {
+		budget = 24000
+	}
+	return budget
+}
+
+// chapterFile names the archive of a finished chapter
+func chapterFile(n int) string {
+	return dataPath(fmt.Sprintf("chapter-%d.json", n))
+}
+
+// endChapter archives the current history, then restarts it from the world
+// prompt, a summary of the chapter and the closing scene
+func endChapter() {
+	n := len(playerState.Chapters) + 1
+	if !confirm(fmt.Sprintf("End chapter %d and archive it to %s?", n, chapterFile(n))) {
+		return
+	}
+	prompt := append([]Message{{Role: "system", Content: summaryPrompt}}, history...)
+	prompt = append(prompt, Message{Role: "user", Content: "Summarize this chapter of the adventure in a short paragraph: " +
+		"where the player has been, who they met, and what remains unresolved."})
+	summary := normalizeText(callOpenAIQuick(prompt))
+	if isDegraded(summary) {
+		fmt.Println(Red + "The chapter summary could not be written; the chapter continues." + Reset)
+		return
+	}
+	if err := writeSave(chapterFile(n), history); err != nil {
+		fmt.Fprintln(os.Stderr, "Chapter archive error:", err)
+		return
+	}
+	playerState.Chapters = append(playerState.Chapters, summary)
+	fresh := []Message{{Role: "system", Content: systemPrompt}}
+	var carried []string
+	for i, sum := range playerState.Chapters {
+		carried = append(carried, fmt.Sprintf("Chapter %d: %s", i+1, sum))
+	}
+	fresh = append(fresh, Message{Role: "system", Content: summaryPrefix + strings.Join(carried, "\n")})
+	if last := len(history) - 1; last >= 0 && history[last].Role == "assistant" {
+		fresh = append(fresh, history[last])
+	}
+	history = fresh
+	fmt.Printf(Magenta+"Chapter %d ends."+Reset+"\n", n)
+	fmt.Println(Blue + summary + Reset)
+	fmt.Printf(Magenta+"Chapter %d begins."+Reset+"\n", n+1)
+}
+
+// listChapters prints the archived chapters and their summaries
+func listChapters() {
+	if len(playerState.Chapters) == 0 {
+		fmt.Println("No chapters have ended yet. Use 'chapter end' to close one.")
+		return
+	}
+	for i, sum := range playerState.Chapters {
+		fmt.Printf(Magenta+"Chapter %d"+Reset+" (%s)\n  %s\n", i+1, chapterFile(i+1), sum)
+	}
+	fmt.Printf(Dim+"Now playing chapter %d."+Reset+"\n", len(playerState.Chapters)+1)
+}
+
+// recapRefreshTokens is how far history must change before recap regenerates
+const recapRefreshTokens = 400
+
+// recap prints a short narrative summary of the adventure, reusing the last
+// one unless history has changed meaningfully since
+func recap() {
+	tokens := historyTokens(history)
+	if recapText == "" || tokens-recapTokens >= recapRefreshTokens || recapTokens-tokens >= recapRefreshTokens {
+		var msgs []Message
+		for i, m := range history {
+			if i == 0 && m.Role == "system" && !isSummary(m) {
+				continue
 			}
+			msgs = append(msgs, m)
+		}
+		if len(msgs) == 0 {
+			fmt.Println("Your adventure has yet to begin.")
+			return
+		}
+		prompt := append([]Message{{Role: "system", Content: summaryPrompt}}, msgs...)
+		prompt = append(prompt, Message{Role: "user", Content: "Summarize my adventure so far in a few sentences, " +
+			"as a storyteller reminding a returning player where things stand. Do not invent new events."})
+		text := normalizeText(callOpenAIQuick(prompt))
+		if isDegraded(text) {
+			fmt.Println(Red + "The recap could not be written just now." + Reset)
+			return
+		}
+		recapText, recapTokens = text, tokens
+	}
+	fmt.Println(Magenta + "The story so far…" + Reset)
+	fmt.Println(Blue + recapText + Reset)
+}
+
+// Prune history by folding the oldest messages into a rolling summary, a batch at a time
+func pruneHistory(msgs []Message) []Message {
+	out := pruneMessages(msgs, historyBudget(), pruneTailTokens)
+	if len(out) != len(msgs) {
+		fmt.Println(Yellow + "[History pruned and summarized]" + Reset)
+	}
+	return out
+}
+
+// pruneMessages folds the oldest messages past budget into a rolling summary
+// placed after the leading system prompt, keeping the last tail tokens as is
+func pruneMessages(msgs []Message, budget, tail int) []Message {
+	total := historyTokens(msgs)
+	if total <= budget {
+		return msgs
+	}
+	head := 0
+	if len(msgs) > 0 && msgs[0].Role == "system" && !isSummary(msgs[0]) {
+		head = 1
+	}
+	start, summary := head, ""
+	if head < len(msgs) && isSummary(msgs[head]) {
+		summary = strings.TrimPrefix(msgs[head].Content, summaryPrefix)
+		start++
+	}
+	// the most recent messages within the tail budget are never summarized
+	tailStart, tailTokens := len(msgs), 0
+	for tailStart > start && tailTokens+estimateTokens(msgs[tailStart-1]) <= tail {
+		tailStart--
+		tailTokens += estimateTokens(msgs[tailStart])
+	}
+	// fold just enough to drop below budget with a little slack
+	target := total - budget + budget/8
+	n, folded := 0, 0
+	for start+n < tailStart && folded < target {
+		folded += estimateTokens(msgs[start+n])
+		n++
+	}
+	if n == 0 {
+		return msgs
+	}
+	prompt := []Message{{Role: "system", Content: summaryPrompt}}
+	if summary != "" {
+		prompt = append(prompt, Message{Role: "system", Content: "Summary of earlier events: " + summary +
+			"\nUpdate this summary to also cover the messages that follow."})
+	}
+	prompt = append(prompt, msgs[start:start+n]...)
+	updated := callOpenAIQuick(prompt)
+	if isDegraded(updated) {
+		return msgs
+	}
+	newHist := append([]Message{}, msgs[:head]...)
+	newHist = append(newHist, Message{Role: "system", Content: summaryPrefix + updated})
+	newHist = append(newHist, msgs[start+n:]...)
+	return newHist
+}
+
+// conversationTokens is the size above which a conversation's older turns
+// are summarized; the system prompt with the NPC's bio always stays on top
+var conversationTokens = 3000
+
+// pruneConversation summarizes the older turns of a long conversation,
+// keeping roughly the last half of the budget word for word
+func pruneConversation(conv []Message) []Message {
+	if conversationTokens <= 0 {
+		return conv
+	}
+	out := pruneMessages(conv, conversationTokens, conversationTokens/2)
+	if len(out) != len(conv) {
+		fmt.Println(Dim + "[Earlier conversation summarized]" + Reset)
+	}
+	return out
+}
+
+// maybePrune summarizes history, when enabled, ahead of a model call that
+// uses it, then enforces the hard cap either way
+func maybePrune() {
+	if pruneEnabled {
+		history = pruneHistory(history)
+	}
+	history = capHistory(history)
+}
+
+// capHistory drops the oldest non-system messages once history would no
+// longer fit the model's context window, keeping room for the reply. The
+// system prompt and rolling summary are always kept.
+func capHistory(msgs []Message) []Message {
+	limit := contextWindow() - 2000
+	total := historyTokens(msgs)
+	if total <= limit {
+		return msgs
+	}
+	out := make([]Message, 0, len(msgs))
+	dropped := 0
+	for i, m := range msgs {
+		if total > limit && m.Role != "system" && i < len(msgs)-1 {
+			total -= estimateTokens(m)
+			dropped++
 			continue
 		}
-		// talk to <name>
-		if strings.HasPrefix(lc, "talk to ") {
-			name := strings.TrimSpace(cmd[8:])
-			if name == "" {
-				fmt.Println("Usage: talk to <full NPC name>")
-			} else {
-				startConversation(name)
+		out = append(out, m)
+	}
+	if !capWarned {
+		fmt.Printf(Yellow+"[History reached the model's context limit; dropped the %d oldest messages. "+
+			"Turn on 'set prune on' to summarize instead.]"+Reset+"\n", dropped)
+		capWarned = true
+	}
+	return out
+}
+
+// List items in scene via AI
+func listItems(msgs []Message) []string {
+	prompt := append(msgs, Message{Role: "user", Content: "List, in a comma-separated list, all objects present in this scene. If none, reply 'None'."})
+	out := cleanList(callOpenAIQuick(prompt))
+	lastItems, lastItemsLoc = out, playerState.CurrentLocation
+	return out
+}
+
+// Limits for names parsed from the model's comma-separated lists
+const (
+	maxListEntries = 12
+	maxNameWords   = 6
+)
+
+// cleanList splits a comma-separated model reply into distinct names,
+// dropping articles and conjunctions, "None" and anything too long to be a
+// name rather than a sentence
+func cleanList(raw string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, p := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' }) {
+		name := strings.Trim(strings.TrimSpace(p), ".!?:;\"'-* ")
+		for _, w := range []string{"and ", "or ", "the ", "a ", "an "} {
+			if len(name) > len(w) && strings.EqualFold(name[:len(w)], w) {
+				name = strings.TrimSpace(name[len(w):])
 			}
-			continue
 		}
-		// look/observe/where
-		if lc == "look" || lc == "observe" || lc == "where" {
-			history = append(history, Message{Role: "user", Content: cmd})
-			desc := normalizeText(callOpenAI(history))
-			fmt.Println()
-			fmt.Println(Blue + desc + Reset)
-			history = append(history, Message{Role: "assistant", Content: desc})
-			printEnvironmentSummary(history)
+		key := strings.ToLower(name)
+		if name == "" || key == "none" || seen[key] || len(strings.Fields(name)) > maxNameWords {
 			continue
 		}
-		// examine / look at / inspect commands
-		handled := false
-		for _, pref := range []string{"examine ", "look at ", "inspect "} {
-			if strings.HasPrefix(lc, pref) {
-				target := strings.TrimSpace(cmd[len(pref):])
-				if target == "" {
-					fmt.Println("Usage: examine <object>")
-				} else {
-					history = append(history, Message{Role: "user", Content: cmd})
-					desc := normalizeText(callOpenAI(history))
-					fmt.Println(Blue + desc + Reset)
-					itemsData[target] = desc
-					playerState.Journal = append(playerState.Journal, fmt.Sprintf("Examined %s.", target))
-					history = append(history, Message{Role: "assistant", Content: desc})
-				}
-				handled = true
-				break
-			}
+		seen[key] = true
+		out = append(out, name)
+		if len(out) == maxListEntries {
+			break
+		}
+	}
+	return out
+}
+
+// List exits via AI
+func listExits(msgs []Message) []string {
+	prompt := append(msgs, Message{Role: "user", Content: "List, in a comma-separated list, all exits or directions available from this scene. " +
+		"Where an exit leads to a named place, write it as '<exit> to <place>'. If none, reply 'None'."})
+	return cleanList(callOpenAIQuick(prompt))
+}
+
+// List NPCs via AI, remembering the result for name resolution
+func listNpcs(msgs []Message) []string {
+	prompt := append(msgs, Message{Role: "user", Content: "List, in a comma-separated list, the FULL NAMES of all NPCs currently present in this scene. If none, reply 'None'."})
+	out := cleanList(callOpenAIQuick(prompt))
+	lastNpcs, lastNpcsLoc = out, playerState.CurrentLocation
+	for _, n := range out {
+		if info, ok := npcData[n]; ok {
+			info.LastSeen = playerState.Day
+		}
+	}
+	return out
+}
+
+// Words ignored when fuzzily matching names
+var matchStopWords = map[string]bool{"the": true, "a": true, "an": true, "of": true, "to": true, "by": true, "with": true, "and": true}
+
+// matchNames returns the candidates matching a typed fragment: an exact
+// (case-insensitive) match if there is one, else substring matches, else
+// candidates sharing a significant word with the fragment
+func matchNames(fragment string, candidates []string) []string {
+	frag := strings.ToLower(strings.TrimSpace(fragment))
+	if frag == "" {
+		return nil
+	}
+	for _, c := range candidates {
+		if strings.ToLower(c) == frag {
+			return []string{c}
 		}
-		if handled {
+	}
+	var subs, words []string
+	for _, c := range candidates {
+		lc := strings.ToLower(c)
+		if strings.Contains(lc, frag) || strings.Contains(frag, lc) {
+			subs = append(subs, c)
 			continue
 		}
-		// movement
-		moved := false
-		var dest string
-		for _, pref := range []string{"go to ", "move to ", "travel to "} {
-			if strings.HasPrefix(lc, pref) {
-				dest = titleCase(cmd[len(pref):])
-				moved = true
+		cw := strings.Fields(lc)
+		for _, w := range strings.Fields(frag) {
+			if len(w) > 2 && !matchStopWords[w] && contains(cw, w) {
+				words = append(words, c)
 				break
 			}
 		}
-		if !moved {
-			switch lc {
-			case "north", "south", "east", "west":
-				dest = titleCase(lc)
-				moved = true
-			}
-		}
-		if moved {
-			prev := playerState.CurrentLocation
-			if prev != "" {
-				if playerState.MapGraph[prev] == nil {
-					playerState.MapGraph[prev] = map[string]bool{}
-				}
-				if playerState.MapGraph[dest] == nil {
-					playerState.MapGraph[dest] = map[string]bool{}
-				}
-				playerState.MapGraph[prev][dest] = true
-				playerState.MapGraph[dest][prev] = true
-			}
-			playerState.CurrentLocation = dest
-			if !contains(playerState.VisitedLocations, dest) {
-				playerState.VisitedLocations = append(playerState.VisitedLocations, dest)
+	}
+	if len(subs) > 0 {
+		return subs
+	}
+	return words
+}
+
+// chooseName asks the player to pick one of several matches, returning "" if they don't
+func chooseName(what string, matches []string) string {
+	fmt.Printf(Yellow+"Which %s do you mean?"+Reset+"\n", what)
+	for i, m := range matches {
+		fmt.Printf("  %d) %s\n", i+1, m)
+	}
+	fmt.Print("> ")
+	line, _ := readReply()
+	if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(matches) {
+		return matches[n-1]
+	}
+	for _, m := range matches {
+		if strings.EqualFold(m, line) {
+			return m
+		}
+	}
+	return ""
+}
+
+// splitNames splits "X and Y, Z" into separate names
+func splitNames(s string) []string {
+	var out []string
+	for _, part := range strings.Split(strings.ReplaceAll(s, " and ", ","), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// resolveNpcName maps a typed fragment to the canonical name of an NPC in
+// the scene or already met, falling back to the fragment when nothing matches
+func resolveNpcName(fragment string) string {
+	if len(lastNpcs) == 0 {
+		listNpcs(history)
+	}
+	candidates := append([]string{}, lastNpcs...)
+	for name := range npcData {
+		if !contains(candidates, name) {
+			candidates = append(candidates, name)
+		}
+	}
+	matches := matchNames(fragment, candidates)
+	switch len(matches) {
+	case 0:
+		return fragment
+	case 1:
+		return matches[0]
+	}
+	sort.Strings(matches)
+	return chooseName("person", matches)
+}
+
+// resolveExamineTarget grounds an examine target in the scene: a clear match
+// is used directly, a weak one is confirmed, and a miss returns ""
+func resolveExamineTarget(target string) string {
+	loc := playerState.CurrentLocation
+	frag := strings.ToLower(strings.TrimSpace(target))
+	for _, a := range []string{"the ", "a ", "an "} {
+		frag = strings.TrimPrefix(frag, a)
+	}
+	cached := lastItemsLoc == loc && len(lastItems) > 0
+	if !cached {
+		listItems(history)
+	}
+	var matches []string
+	for {
+		candidates := append([]string{}, lastItems...)
+		for _, it := range playerState.SceneItems[loc] {
+			if !contains(candidates, it) {
+				candidates = append(candidates, it)
+			}
+		}
+		matches = matchNames(frag, candidates)
+		// the cached listing may predate the latest narration
+		if len(matches) > 0 || !cached {
+			break
+		}
+		cached = false
+		listItems(history)
+	}
+	if len(matches) > 1 {
+		sort.Strings(matches)
+		return chooseName("thing", matches)
+	}
+	if len(matches) == 1 {
+		lm := strings.ToLower(matches[0])
+		if strings.Contains(lm, frag) || strings.Contains(frag, lm) {
+			return matches[0]
+		}
+		if confirm(fmt.Sprintf("Did you mean the %s?", matches[0])) {
+			return matches[0]
+		}
+		return ""
+	}
+	// not listed, but named in the scene's own text
+	scene := strings.ToLower(sceneDescriptions[loc])
+	if n := len(history); n > 0 && history[n-1].Role == "assistant" {
+		scene += " " + strings.ToLower(history[n-1].Content)
+	}
+	if frag != "" && strings.Contains(scene, frag) {
+		return target
+	}
+	return ""
+}
+
+// examine describes an object or, when the target names someone, observes
+// that NPC without starting a conversation
+func examine(c Command) {
+	if c.Arg == "" {
+		fmt.Println("Usage: examine <object or person>")
+		return
+	}
+	if len(lastNpcs) == 0 {
+		listNpcs(history)
+	}
+	people := append([]string{}, lastNpcs...)
+	for name := range npcData {
+		if !contains(people, name) {
+			people = append(people, name)
+		}
+	}
+	// only close name matches count, so "old door" never means "Old Tom"
+	var npcs []string
+	frag := strings.ToLower(c.Arg)
+	for _, n := range matchNames(c.Arg, people) {
+		if ln := strings.ToLower(n); strings.Contains(ln, frag) || strings.Contains(frag, ln) {
+			npcs = append(npcs, n)
+		}
+	}
+	if len(npcs) > 0 {
+		var things []string
+		if lastItemsLoc == playerState.CurrentLocation {
+			things = matchNames(c.Arg, append(append([]string{}, lastItems...), playerState.SceneItems[playerState.CurrentLocation]...))
+		}
+		// a person and an object may share a name ("the Oracle" and "oracle stone")
+		options := make([]string, 0, len(npcs)+len(things))
+		for _, n := range npcs {
+			options = append(options, n+" (person)")
+		}
+		for _, t := range things {
+			options = append(options, t+" (object)")
+		}
+		choice := options[0]
+		if len(options) > 1 {
+			sort.Strings(options)
+			choice = chooseName("one", options)
+		}
+		if name, ok := strings.CutSuffix(choice, " (person)"); ok {
+			observeNpc(name)
+			return
+		}
+		if choice == "" {
+			return
+		}
+		c.Arg = strings.TrimSuffix(choice, " (object)")
+	}
+	if name := carriedItem(c.Arg); name != "" {
+		examineCarried(name)
+		return
+	}
+	target := resolveExamineTarget(c.Arg)
+	if target == "" {
+		fmt.Printf(Red+"You don't see '%s' here."+Reset+"\n", c.Arg)
+		return
+	}
+	prompt := c.Raw
+	if target != c.Arg {
+		prompt = "examine the " + target
+	}
+	desc := narrateTurn(prompt)
+	itemsData[target] = desc
+	playerState.Journal = append(playerState.Journal, fmt.Sprintf("Examined %s.", target))
+}
+
+// carriedItem returns the inventory entry an examine target names, or ""
+func carriedItem(target string) string {
+	frag := strings.ToLower(strings.TrimSpace(target))
+	for _, a := range []string{"my ", "the ", "a ", "an "} {
+		frag = strings.TrimPrefix(frag, a)
+	}
+	if i := playerState.Inventory.find(frag); i >= 0 {
+		return playerState.Inventory[i].Name
+	}
+	var names []string
+	for _, it := range playerState.Inventory {
+		names = append(names, it.Name)
+	}
+	// only close matches, so a scene's "iron gate" never means a carried "iron key"
+	var matches []string
+	for _, n := range matchNames(frag, names) {
+		if ln := strings.ToLower(n); strings.Contains(ln, frag) || strings.Contains(frag, ln) {
+			matches = append(matches, n)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return ""
+	case 1:
+		return matches[0]
+	}
+	sort.Strings(matches)
+	return chooseName("item", matches)
+}
+
+// examineCarried describes something in the player's pack, keeping to any
+// earlier description so held gear stays consistent
+func examineCarried(name string) {
+	prompt := fmt.Sprintf("I examine the %s I am carrying.\n(Describe the %s the player is carrying, not anything in the surroundings.", name, name)
+	if prev, ok := itemsData[name]; ok {
+		prompt += " It was described before as: " + prev + " Stay consistent with that."
+	}
+	prompt += ")"
+	desc := narrateTurn(prompt)
+	itemsData[name] = desc
+	playerState.Journal = append(playerState.Journal, fmt.Sprintf("Examined the %s in your pack.", name))
+}
+
+// showLore lists what the player has examined, or reprints what is known of
+// one item or person
+func showLore(target string) {
+	var items, people []string
+	for name := range itemsData {
+		items = append(items, name)
+	}
+	for name, n := range npcData {
+		if n.Observed != "" {
+			people = append(people, name)
+		}
+	}
+	sort.Strings(items)
+	sort.Strings(people)
+	if target == "" {
+		if len(items)+len(people) == 0 {
+			fmt.Println("You haven't examined anything yet.")
+			return
+		}
+		if len(items) > 0 {
+			fmt.Printf(Magenta+"Things:"+Reset+" %s\n", strings.Join(items, ", "))
+		}
+		if len(people) > 0 {
+			fmt.Printf(Magenta+"People:"+Reset+" %s\n", strings.Join(people, ", "))
+		}
+		return
+	}
+	matches := matchNames(target, append(append([]string{}, items...), people...))
+	var name string
+	switch len(matches) {
+	case 0:
+		fmt.Printf(Red+"You know nothing of '%s'."+Reset+"\n", target)
+		return
+	case 1:
+		name = matches[0]
+	default:
+		sort.Strings(matches)
+		if name = chooseName("one", matches); name == "" {
+			return
+		}
+	}
+	fmt.Printf(Green+"%s"+Reset+"\n", name)
+	if desc, ok := itemsData[name]; ok {
+		fmt.Println(Blue + desc + Reset)
+		return
+	}
+	n := npcData[name]
+	fmt.Println(" " + n.Bio)
+	fmt.Println(" " + n.Backstory)
+	if n.Voice != "" {
+		fmt.Println(" Voice: " + n.Voice)
+	}
+	fmt.Println(Blue + n.Observed + Reset)
+}
+
+// observeNpc prints what is known of an NPC and a look at them, without
+// entering the dialogue loop
+func observeNpc(name string) {
+	info := ensureNpc(name)
+	fmt.Printf(Green+"%s"+Reset+"\n", name)
+	fmt.Println(" " + info.Bio)
+	fmt.Println(" " + info.Backstory)
+	if info.Voice != "" {
+		fmt.Println(" Voice: " + info.Voice)
+	}
+	desc := narrateTurn(fmt.Sprintf("I quietly observe %s without speaking to them.\n"+
+		"(Describe only their appearance, manner and what they are doing; they do not address the player.)", name))
+	if !failedNarration(desc) {
+		info.Observed = desc
+	}
+	playerState.Journal = append(playerState.Journal, fmt.Sprintf("Observed %s.", name))
+}
+
+// timeOfDay names the part of the day for an hour on the 24-hour clock
+func timeOfDay(hour int) string {
+	switch {
+	case hour >= 5 && hour < 8:
+		return "Dawn"
+	case hour >= 8 && hour < 12:
+		return "Morning"
+	case hour >= 12 && hour < 14:
+		return "Midday"
+	case hour >= 14 && hour < 18:
+		return "Afternoon"
+	case hour >= 18 && hour < 21:
+		return "Dusk"
+	}
+	return "Night"
+}
+
+// advanceClock moves the in-game clock forward, rolling over into new days
+func advanceClock(hours int) {
+	playerState.Hour += hours
+	for playerState.Hour >= 24 {
+		playerState.Hour -= 24
+		playerState.Day++
+		decayReputation()
+		playerState.Mana = playerState.MaxMana
+	}
+}
+
+// decayReputation moves every standing one step back toward neutral, so old
+// deeds are slowly forgotten
+func decayReputation() {
+	for name, v := range playerState.Reputation {
+		switch {
+		case v > 0:
+			v--
+		case v < 0:
+			v++
+		}
+		if v == 0 {
+			delete(playerState.Reputation, name)
+		} else {
+			playerState.Reputation[name] = v
+		}
+	}
+}
+
+// reputationLabel turns a standing into a word the narrator and player can use
+func reputationLabel(v int) string {
+	switch {
+	case v <= -6:
+		return "hated"
+	case v <= -2:
+		return "distrusted"
+	case v >= 6:
+		return "revered"
+	case v >= 2:
+		return "liked"
+	}
+	return "neutral"
+}
+
+// showReputation lists the player's standing with each faction or region
+func showReputation() {
+	if len(playerState.Reputation) == 0 {
+		fmt.Println("No one has formed an opinion of you yet.")
+		return
+	}
+	var names []string
+	for name := range playerState.Reputation {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println(Blue + "Reputation:" + Reset)
+	for _, name := range names {
+		v := playerState.Reputation[name]
+		fmt.Printf(" %-20s %+d (%s)\n", name, v, reputationLabel(v))
+	}
+}
+
+// weatherOdds is a weighted transition to the next weather state
+type weatherOdds struct {
+	Next   string
+	Weight int
+}
+
+// Weighted weather transitions, rolled on movement and waiting
+var weatherTable = map[string][]weatherOdds{
+	"Clear":  {{"Clear", 6}, {"Cloudy", 3}, {"Windy", 1}},
+	"Cloudy": {{"Cloudy", 3}, {"Clear", 3}, {"Rain", 3}, {"Fog", 1}},
+	"Rain":   {{"Rain", 4}, {"Cloudy", 3}, {"Storm", 2}, {"Fog", 1}},
+	"Storm":  {{"Rain", 5}, {"Storm", 3}, {"Cloudy", 2}},
+	"Fog":    {{"Fog", 3}, {"Cloudy", 4}, {"Clear", 3}},
+	"Windy":  {{"Windy", 3}, {"Clear", 4}, {"Cloudy", 3}},
+}
+
+// Words suggesting a location is sheltered from the weather
+var indoorWords = []string{"tavern", "inn", "temple", "home", "house", "shop", "hall", "library",
+	"cellar", "room", "chamber", "castle", "tower", "church", "cave", "crypt", "mine", "inside"}
+
+// shiftWeather rolls the next weather state from the transition table
+func shiftWeather() {
+	odds, ok := weatherTable[playerState.Weather]
+	if !ok {
+		odds = weatherTable["Clear"]
+	}
+	total := 0
+	for _, o := range odds {
+		total += o.Weight
+	}
+	r := rng.Intn(total)
+	for _, o := range odds {
+		if r < o.Weight {
+			playerState.Weather = o.Next
+			return
+		}
+		r -= o.Weight
+	}
+}
+
+// isIndoors guesses from its name whether a location is sheltered
+func isIndoors(loc string) bool {
+	low := strings.ToLower(loc)
+	for _, w := range indoorWords {
+		if strings.Contains(low, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// worldContext describes the current world state for the narrator
+func worldContext() string {
+	ctx := fmt.Sprintf("Current time: Day %d, %s (%02d:00). Describe light and activity to match; "+
+		"people keep sensible hours, so shopkeepers and workers may be absent at night.",
+		playerState.Day, timeOfDay(playerState.Hour), playerState.Hour)
+	if pc := playerContext(); pc != "" {
+		ctx += "\n" + pc
+	}
+	if toolsEnabled {
+		ctx += "\n" + toolContext
+	} else {
+		ctx += "\n" + markerContext
+	}
+	if dc := difficultyContext(); dc != "" {
+		ctx += "\n" + dc
+	}
+	if len(playerState.Effects) > 0 {
+		ctx += "\nThe player is suffering from: " + effectsSummary() + "."
+	}
+	if travelCost > 0 && playerState.Stamina*4 <= playerState.MaxStamina {
+		ctx += "\nThe player is worn out from travel; let their fatigue show."
+	}
+	if !slices.Equal(playerState.StatNames, defaultStatNames) {
+		ctx += "\nThe player's attributes are " + strings.Join(playerState.StatNames, ", ") + "."
+	}
+	if rc := reputationContext(); rc != "" {
+		ctx += "\n" + rc
+	}
+	if uiLang != "en" {
+		ctx += "\nThe player reads " + languageNames[uiLang] + "; narrate in " + languageNames[uiLang] + "."
+	}
+	if isIndoors(playerState.CurrentLocation) {
+		ctx += fmt.Sprintf("\nWeather outside: %s. The player is indoors, so only hint at it (muffled sounds, wet cloaks).", playerState.Weather)
+	} else {
+		ctx += fmt.Sprintf("\nWeather: %s. The player is outdoors; keep the weather vivid and consistent with earlier scenes.", playerState.Weather)
+	}
+	return ctx
+}
+
+// reputationContext lists known standings so locals react to the player's
+// deeds, and tells the narrator how to record new ones
+func reputationContext() string {
+	ctx := "When the player's actions would change how a faction, town or region regards them, " +
+		"include [REP:<faction>:<+n or -n>] in your reply."
+	if len(playerState.Reputation) == 0 {
+		return ctx
+	}
+	var standings []string
+	for name, v := range playerState.Reputation {
+		standings = append(standings, fmt.Sprintf("%s: %s (%+d)", name, reputationLabel(v), v))
+	}
+	sort.Strings(standings)
+	return ctx + " Known standings (apply those relevant to " + playerState.CurrentLocation + "): " +
+		strings.Join(standings, "; ") + "."
+}
+
+// playerContext describes the player character, if they described themselves
+func playerContext() string {
+	var parts []string
+	if playerState.Name != "" {
+		parts = append(parts, "The player character is named "+playerState.Name+"; address them by name when it fits.")
+	}
+	if playerState.Description != "" {
+		parts = append(parts, "They describe themselves as: "+playerState.Description)
+	}
+	if playerState.Class != "" && playerState.Class != "Adventurer" {
+		parts = append(parts, "They are a "+playerState.Class+"; let their training color what they notice and how they act.")
+	}
+	if playerState.Appearance != "" {
+		parts = append(parts, "Their appearance: "+playerState.Appearance)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, " ") + " People may react to how they look."
+}
+
+// withWorldContext returns msgs followed by a system note on the world state
+func withWorldContext(msgs []Message) []Message {
+	out := make([]Message, 0, len(msgs)+1)
+	out = append(out, msgs...)
+	return append(out, Message{Role: "system", Content: worldContext()})
+}
+
+// Print environment summary (exits, NPCs, items)
+func printEnvironmentSummary(msgs []Message) {
+	exits := listExits(msgs)
+	for _, e := range exits {
+		if i := strings.LastIndex(strings.ToLower(e), " to "); i > 0 {
+			place := titleCase(strings.TrimSpace(e[i+4:]))
+			if place != "" && place != playerState.CurrentLocation && !contains(playerState.VisitedLocations, place) {
+				recordFrontier(playerState.CurrentLocation, place)
+			}
+		}
+	}
+	npcs := listNpcs(msgs)
+	items := listItems(msgs)
+	fmt.Printf(Blue+"Exits:"+Reset+" %s\n", strings.Join(exits, ", "))
+	fmt.Printf(Green+"NPCs here:"+Reset+" %s\n", strings.Join(npcs, ", "))
+	fmt.Printf(Yellow+"Items here:"+Reset+" %s\n", strings.Join(items, ", "))
+}
+
+// The classic attribute set, used unless .advrc sets "stats = A, B, ..."
+var defaultStatNames = []string{"STR", "DEX", "CON", "INT", "WIS", "CHA"}
+
+// statRoll picks how new characters roll each attribute: "range" (8-18),
+// "3d6" or "4d6" (four dice, dropping the lowest)
+var statRoll = "range"
+
+// configuredStats returns the attribute names from .advrc, or the defaults
+func configuredStats() []string {
+	var names []string
+	for _, n := range strings.Split(rcSettings["stats"], ",") {
+		n = strings.Join(strings.Fields(strings.ToUpper(n)), "-")
+		if n != "" && n != "HP" && !contains(names, n) {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return append([]string{}, defaultStatNames...)
+	}
+	return names
+}
+
+// rollStat rolls one attribute by the chosen method
+func rollStat() int {
+	switch statRoll {
+	case "3d6":
+		return rng.Intn(6) + rng.Intn(6) + rng.Intn(6) + 3
+	case "4d6":
+		dice := []int{rng.Intn(6) + 1, rng.Intn(6) + 1, rng.Intn(6) + 1, rng.Intn(6) + 1}
+		sort.Ints(dice)
+		return dice[1] + dice[2] + dice[3]
+	}
+	return rng.Intn(11) + 8
+}
+
+// statValue returns an attribute, or an average 10 when the stat set lacks it
+func statValue(name string) int {
+	if v, ok := playerState.Stats[name]; ok {
+		return v
+	}
+	return 10
+}
+
+// Initialize new player state
+func initPlayerState() {
+	names := configuredStats()
+	stats := map[string]int{}
+	for _, s := range names {
+		stats[s] = rollStat()
+	}
+	playerState = PlayerState{
+		Stats:            stats,
+		StatNames:        names,
+		Checks:           defaultChecks(names),
+		Inventory:        Inventory{},
+		Journal:          []string{},
+		VisitedLocations: []string{},
+		MapGraph:         map[string]map[string]bool{},
+		CurrentLocation:  "",
+		SceneItems:       map[string][]string{},
+		Day:              1,
+		Hour:             8,
+		Weather:          "Clear",
+		Class:            "Adventurer",
+		Weights:          map[string]int{},
+		Categories:       map[string]string{},
+		Frontiers:        map[string]map[string]bool{},
+		Difficulty:       startDifficulty,
+	}
+	playerState.MaxHP = baseMaxHP()
+	playerState.HP = playerState.MaxHP
+	playerState.Capacity = baseCapacity()
+	playerState.MaxMana = baseMana()
+	playerState.Mana = playerState.MaxMana
+	playerState.MaxStamina = baseStamina()
+	playerState.Stamina = playerState.MaxStamina
+}
+
+// CharClass is a starting template: stats in priority order and a starting item
+type CharClass struct {
+	Name     string
+	Priority []string
+	Item     string
+	Category string
+	Spells   []string
+}
+
+// Classes offered at character creation; Adventurer keeps random stats
+var classes = []CharClass{
+	{Name: "Adventurer"},
+	{Name: "Warrior", Priority: []string{"STR", "CON", "DEX", "WIS", "CHA", "INT"}, Item: "longsword", Category: "Weapon"},
+	{Name: "Rogue", Priority: []string{"DEX", "INT", "CHA", "CON", "WIS", "STR"}, Item: "set of lockpicks", Category: "Misc"},
+	{Name: "Sage", Priority: []string{"INT", "WIS", "CON", "CHA", "DEX", "STR"}, Item: "worn spellbook", Category: "Key Item", Spells: []string{"light", "mend"}},
+	{Name: "Bard", Priority: []string{"CHA", "DEX", "INT", "WIS", "CON", "STR"}, Item: "lute", Category: "Misc"},
+}
+
+// applyClass rearranges the rolled stats so the class's key attributes get
+// the highest rolls, and grants its starting item
+func applyClass(cc CharClass) {
+	playerState.Class = cc.Name
+	fits := len(cc.Priority) > 0
+	for _, stat := range cc.Priority {
+		if _, ok := playerState.Stats[stat]; !ok {
+			// a custom stat set keeps its random rolls
+			fits = false
+		}
+	}
+	if fits {
+		var rolls []int
+		for _, v := range playerState.Stats {
+			rolls = append(rolls, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(rolls)))
+		for i, stat := range cc.Priority {
+			playerState.Stats[stat] = rolls[i]
+		}
+		playerState.MaxHP = baseMaxHP()
+		playerState.HP = playerState.MaxHP
+		playerState.Capacity = baseCapacity()
+		playerState.MaxMana = baseMana()
+		playerState.Mana = playerState.MaxMana
+		playerState.MaxStamina = baseStamina()
+		playerState.Stamina = playerState.MaxStamina
+	}
+	playerState.Spells = append(playerState.Spells, cc.Spells...)
+	if cc.Item != "" {
+		playerState.Categories[strings.ToLower(cc.Item)] = cc.Category
+		addItem(cc.Item)
+	}
+}
+
+// chooseClass offers the class templates, defaulting to Adventurer
+func chooseClass() {
+	fmt.Println("Choose your class:")
+	for i, cc := range classes {
+		desc := "balanced, random stats"
+		if len(cc.Priority) > 0 {
+			desc = fmt.Sprintf("favors %s and %s; starts with a %s", cc.Priority[0], cc.Priority[1], cc.Item)
+		}
+		fmt.Printf("  %d) %-10s - %s\n", i+1, cc.Name, desc)
+	}
+	fmt.Print("> ")
+	ans, _ := readReply()
+	choice := classes[0]
+	for i, cc := range classes {
+		if ans == strconv.Itoa(i+1) || strings.EqualFold(ans, cc.Name) {
+			choice = cc
+		}
+	}
+	applyClass(choice)
+	fmt.Printf(Yellow+"You are a %s."+Reset+"\n", choice.Name)
+}
+
+// baseMaxHP derives maximum hit points from CON
+func baseMaxHP() int {
+	return 10 + (statValue("CON")-10)/2
+}
+
+// baseStamina derives how far the player can travel between rests from CON
+func baseStamina() int {
+	return max(3, 8+(statValue("CON")-10)/2)
+}
+
+// writeSave encodes the game state to a JSON file
+func writeSave(path string, msgs []Message) error {
+	d := SaveData{NpcData: npcData, PlayerState: playerState, History: msgs, SceneDescriptions: sceneDescriptions, Theme: themeName, WorldPrompt: systemPrompt, AmbientLines: ambientLines, ItemsData: itemsData,
+		SummaryPrompt: summaryPrompt, Seed: rngSeed, RandDraws: rngSrc.draws}
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// Save game to JSON file
+func saveGame(msgs []Message) {
+	if err := writeSave(dataPath(saveFile), msgs); err != nil {
+		fmt.Fprintln(os.Stderr, "Save file error:", err)
+		return
+	}
+	if err := rotateSaves(); err != nil {
+		fmt.Fprintln(os.Stderr, "Snapshot error:", err)
+	}
+	fmt.Println(Yellow + "Game saved to " + dataPath(saveFile) + "." + Reset)
+}
+
+// defaultAutosave lists the commands that save the game when .advrc
+// sets no autosave-triggers
+var defaultAutosave = []string{"move", "talk"}
+
+// autosaveTriggers returns the command names that save the game after
+// running; "none" turns autosaving off
+func autosaveTriggers() []string {
+	v, ok := rcSettings["autosave-triggers"]
+	if !ok {
+		return defaultAutosave
+	}
+	return slices.DeleteFunc(commandList(v), func(n string) bool { return n == "none" })
+}
+
+// commandList splits "move, talk" or "[move, talk]" into lowercase names
+func commandList(v string) []string {
+	return strings.FieldsFunc(strings.ToLower(strings.Trim(v, "[] ")), func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// maybeAutosave quietly saves once a game is under way, if v is a trigger
+func maybeAutosave(v Verb) {
+	if playerState.CurrentLocation == "" || !contains(autosaveTriggers(), v.String()) {
+		return
+	}
+	if err := writeSave(dataPath(saveFile), history); err != nil {
+		fmt.Fprintln(os.Stderr, "Autosave error:", err)
+		return
+	}
+	if err := rotateSaves(); err != nil {
+		fmt.Fprintln(os.Stderr, "Snapshot error:", err)
+	}
+	fmt.Println(Dim + "(autosaved)" + Reset)
+}
+
+// setAutosave shows or replaces the autosave triggers, keeping them in .advrc
+func setAutosave(arg string) {
+	if arg == "" {
+		names := autosaveTriggers()
+		if len(names) == 0 {
+			fmt.Println("Autosave is off.")
+		} else {
+			fmt.Println("Autosave after: " + strings.Join(names, ", "))
+		}
+		return
+	}
+	var names []string
+	for _, n := range commandList(arg) {
+		if n != "none" && !slices.Contains(verbNames[:], n) {
+			fmt.Printf(Red+"Unknown command %q; use names like move, talk, rest, back or none."+Reset+"\n", n)
+			return
+		}
+		if n != "none" && !contains(names, n) {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		rcSettings["autosave-triggers"] = "none"
+		fmt.Println("Autosave is off.")
+	} else {
+		rcSettings["autosave-triggers"] = strings.Join(names, ", ")
+		fmt.Println("Autosave after: " + rcSettings["autosave-triggers"])
+	}
+	if err := saveRC(); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write .advrc:", err)
+	}
+}
+
+// saveRing is how many recent saves are kept, save-1.json being the newest
+var saveRing = 5
+
+// ringFile is the path of the nth most recent save snapshot
+func ringFile(n int) string {
+	return dataPath(fmt.Sprintf("save-%d.json", n))
+}
+
+// rotateSaves moves each snapshot one place down the ring, dropping the
+// oldest, and copies the save just written in as save-1.json
+func rotateSaves() error {
+	if saveRing <= 0 {
+		return nil
+	}
+	os.Remove(ringFile(saveRing))
+	for i := saveRing - 1; i >= 1; i-- {
+		if _, err := os.Stat(ringFile(i)); err == nil {
+			if err := os.Rename(ringFile(i), ringFile(i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	b, err := ioutil.ReadFile(dataPath(saveFile))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ringFile(1), b, 0644)
+}
+
+// describeSnapshot summarizes a snapshot as its location, game day and
+// when it was written, or "" if it can't be read
+func describeSnapshot(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var d SaveData
+	if err := json.Unmarshal(b, &d); err != nil {
+		return ""
+	}
+	loc := d.PlayerState.CurrentLocation
+	if loc == "" {
+		loc = "nowhere yet"
+	}
+	return fmt.Sprintf("%s, day %d (saved %s)", loc, max(1, d.PlayerState.Day), fi.ModTime().Format("Jan 2 15:04"))
+}
+
+// savesCmd lists the save snapshots or restores one after a preview
+func savesCmd(arg string) {
+	f := strings.Fields(strings.ToLower(arg))
+	if len(f) == 0 {
+		found := false
+		for i := 1; i <= saveRing; i++ {
+			if desc := describeSnapshot(ringFile(i)); desc != "" {
+				if !found {
+					fmt.Println(Blue + "Save snapshots (newest first):" + Reset)
+					found = true
+				}
+				fmt.Printf(" %d) %s\n", i, desc)
+			}
+		}
+		if !found {
+			fmt.Println("No save snapshots yet; each save keeps one.")
+		}
+		return
+	}
+	var n int
+	if len(f) == 2 && f[0] == "restore" {
+		n, _ = strconv.Atoi(f[1])
+	}
+	if n < 1 {
+		fmt.Println("Usage: saves [restore <n>]")
+		return
+	}
+	desc := describeSnapshot(ringFile(n))
+	if desc == "" {
+		fmt.Printf(Red+"There is no snapshot %d."+Reset+"\n", n)
+		return
+	}
+	if !confirm(fmt.Sprintf("Restore snapshot %d: %s? Unsaved progress will be lost.", n, desc)) {
+		return
+	}
+	h, err := readSave(ringFile(n))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Save file error:", err)
+		return
+	}
+	history = h
+	if len(history) > 0 && history[len(history)-1].Role == "assistant" {
+		fmt.Println(Blue + history[len(history)-1].Content + Reset)
+	}
+}
+
+// readSave restores game state from a JSON file, returning its history
+func readSave(path string) ([]Message, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d SaveData
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+	npcData = d.NpcData
+	if npcData == nil {
+		npcData = map[string]*Npc{}
+	}
+	playerState = d.PlayerState
+	// caches from the game being replaced would point into the wrong story
+	lastNpcs, lastNpcsLoc, lastItems, lastItemsLoc = nil, "", nil, ""
+	recapText, recapTokens, regenReady = "", 0, false
+	if d.Seed != 0 {
+		seedRNG(d.Seed, d.RandDraws)
+	}
+	if d.Theme != "" {
+		themeName = d.Theme
+	}
+	// a prompt given on the command line wins over the saved one
+	if d.SummaryPrompt != "" && summaryPromptFile == "" {
+		summaryPrompt = d.SummaryPrompt
+	}
+	// WorldPrompt is the source of truth; older saves only carry it in history
+	switch {
+	case d.WorldPrompt == "":
+		if len(d.History) > 0 && d.History[0].Role == "system" {
+			systemPrompt = d.History[0].Content
+		}
+	case len(d.History) == 0:
+		systemPrompt = d.WorldPrompt
+	case d.History[0].Role != "system":
+		fmt.Println(Yellow + "Warning: the saved history had no world prompt; restoring it." + Reset)
+		systemPrompt = d.WorldPrompt
+		d.History = append([]Message{{Role: "system", Content: systemPrompt}}, d.History...)
+	default:
+		systemPrompt = d.WorldPrompt
+		if d.History[0].Content != d.WorldPrompt {
+			fmt.Println(Yellow + "Warning: the saved history's world prompt differs from the save's; using the saved world prompt." + Reset)
+			d.History[0].Content = d.WorldPrompt
+		}
+	}
+	sceneDescriptions = d.SceneDescriptions
+	if sceneDescriptions == nil {
+		sceneDescriptions = map[string]string{}
+	}
+	ambientLines = d.AmbientLines
+	if ambientLines == nil {
+		ambientLines = map[string]string{}
+	}
+	itemsData = d.ItemsData
+	if itemsData == nil {
+		itemsData = map[string]string{}
+	}
+	if playerState.Day == 0 {
+		playerState.Day, playerState.Hour = 1, 8
+	}
+	if playerState.Weather == "" {
+		playerState.Weather = "Clear"
+	}
+	if playerState.MaxHP == 0 {
+		playerState.MaxHP = baseMaxHP()
+		playerState.HP = playerState.MaxHP
+	}
+	if playerState.Class == "" {
+		playerState.Class = "Adventurer"
+	}
+	if playerState.Weights == nil {
+		playerState.Weights = map[string]int{}
+	}
+	if playerState.Capacity == 0 {
+		playerState.Capacity = baseCapacity()
+	}
+	if playerState.Categories == nil {
+		playerState.Categories = map[string]string{}
+	}
+	if playerState.Frontiers == nil {
+		playerState.Frontiers = map[string]map[string]bool{}
+	}
+	if n := repairMap(); n > 0 {
+		fmt.Printf(Yellow+"Repaired %d inconsistent map connections."+Reset+"\n", n)
+	}
+	if playerState.SceneItems == nil {
+		playerState.SceneItems = map[string][]string{}
+	}
+	if _, ok := difficultyDC[playerState.Difficulty]; !ok {
+		playerState.Difficulty = "normal"
+	}
+	if playerState.MaxMana == 0 {
+		playerState.MaxMana = baseMana()
+		playerState.Mana = playerState.MaxMana
+	}
+	if playerState.MaxStamina == 0 {
+		playerState.MaxStamina = baseStamina()
+		playerState.Stamina = playerState.MaxStamina
+	}
+	playerState.Stamina = max(0, min(playerState.MaxStamina, playerState.Stamina))
+	if len(playerState.StatNames) == 0 {
+		for _, k := range defaultStatNames {
+			if _, ok := playerState.Stats[k]; ok {
+				playerState.StatNames = append(playerState.StatNames, k)
+			}
+		}
+	}
+	if playerState.Checks == nil {
+		playerState.Checks = defaultChecks(playerState.StatNames)
+	}
+	// older saves don't say when NPCs were seen; count them as seen today
+	for _, info := range npcData {
+		if info.LastSeen == 0 {
+			info.LastSeen = playerState.Day
+		}
+	}
+	if encumbered() {
+		fmt.Printf(Red+"You are carrying %d/%d and are over-encumbered: STR and DEX checks suffer -2 until you drop something."+Reset+"\n",
+			carriedWeight(), playerState.Capacity)
+	}
+	fmt.Println(Yellow + "Game loaded from " + path + "." + Reset)
+	return d.History, nil
+}
+
+// Load game from JSON file
+func loadGame() ([]Message, error) {
+	return readSave(dataPath(saveFile))
+}
+
+// repairMap makes MapGraph symmetric, dropping self-loops and edges to
+// places that were never recorded, and returns how many edges it fixed
+func repairMap() int {
+	if playerState.MapGraph == nil {
+		playerState.MapGraph = map[string]map[string]bool{}
+	}
+	g := playerState.MapGraph
+	known := func(n string) bool {
+		_, ok := g[n]
+		return ok || n == playerState.CurrentLocation || contains(playerState.VisitedLocations, n)
+	}
+	fixed := 0
+	for a, ns := range g {
+		for b, ok := range ns {
+			if !ok || a == b || b == "" || !known(b) {
+				delete(ns, b)
+				fixed++
+			}
+		}
+	}
+	for a, ns := range g {
+		for b := range ns {
+			if g[b] == nil {
+				g[b] = map[string]bool{}
+			}
+			if !g[b][a] {
+				g[b][a] = true
+				fixed++
+			}
+		}
+	}
+	return fixed
+}
+
+// handleShutdown writes an emergency save when the process is interrupted or
+// terminated. It takes stateMu first, so it never snapshots a half-applied
+// command; main only releases the lock while waiting for input, so any model
+// request in flight is cancelled rather than waited out.
+func handleShutdown() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancelRequests()
+		withState(func() {
+			fmt.Print(Reset + "\n")
+			if len(history) > 0 {
+				if err := writeSave(dataPath(crashFile), history); err != nil {
+					fmt.Fprintln(os.Stderr, "Emergency save failed:", err)
+				} else {
+					fmt.Println(Yellow + "Emergency save written to " + dataPath(crashFile) + "." + Reset)
+				}
+			}
+			os.Exit(130)
+		})
+	}()
+}
+
+// lockState marks the start of a state change on the main goroutine
+func lockState() {
+	stateMu.Lock()
+	stateHeld = true
+}
+
+// unlockState marks the end of a state change on the main goroutine
+func unlockState() {
+	stateHeld = false
+	stateMu.Unlock()
+}
+
+// withState runs fn holding stateMu. Goroutines other than main must touch
+// game state only through it; main holds the lock except while waiting for
+// input, so fn runs between commands.
+func withState(fn func()) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	fn()
+}
+
+// ensureNpc returns the stored NPC, generating a bio and backstory on first meeting
+func ensureNpc(npcName string) *Npc {
+	if _, ok := npcData[npcName]; !ok {
+		bio, backstory, kind := npcProfile(npcName, 6)
+		if bio == "" {
+			bio = fmt.Sprintf("%s, a person of note.", npcName)
+		}
+		if backstory == "" {
+			backstory = "They prefer to keep much of their past private."
+		}
+		npcData[npcName] = &Npc{Bio: bio, Backstory: backstory, Affinity: 0, Kind: kind}
+	}
+	npcData[npcName].LastSeen = playerState.Day
+	return npcData[npcName]
+}
+
+// npcProfile asks the narrator who npcName is, going by the last recent
+// messages; any part it fails to give comes back ""
+func npcProfile(npcName string, recent int) (bio, backstory, kind string) {
+	last := history
+	if len(last) > recent {
+		last = last[len(last)-recent:]
+	}
+	prompt := append(append([]Message{}, last...), Message{Role: "user", Content: fmt.Sprintf(
+		"You previously described an NPC named '%s'.\n"+
+			"Please provide THREE clearly labeled sections:\n"+
+			"KIND: 'person', or 'creature' for an animal or being that does not converse like a person.\n"+
+			"BIO: One sentence describing who they are (name/title/role, or nature for a creature).\n"+
+			"BACKSTORY: Two sentences about their past, interests, or beliefs (or habits, for a creature).\n"+
+			"Respond exactly in this format.", npcName)})
+	summary := callOpenAI(prompt)
+	for _, line := range strings.Split(summary, "\n") {
+		up := strings.ToUpper(line)
+		if strings.HasPrefix(up, "KIND:") && strings.Contains(up, "CREATURE") {
+			kind = "creature"
+		}
+		if strings.HasPrefix(up, "BIO:") {
+			bio = strings.TrimSpace(line[4:])
+		}
+		if strings.HasPrefix(up, "BACKSTORY:") {
+			backstory = strings.TrimSpace(line[10:])
+		}
+	}
+	if looksLikeCreature(npcName) {
+		kind = "creature"
+	}
+	return bio, backstory, kind
+}
+
+// metNpc resolves a name among the NPCs met, asking when several match;
+// it returns "" after saying why when none does
+func metNpc(arg string) string {
+	names := make([]string, 0, len(npcData))
+	for n := range npcData {
+		names = append(names, n)
+	}
+	matches := matchNames(arg, names)
+	switch len(matches) {
+	case 0:
+		fmt.Printf(Red+"You haven't met anyone called '%s'."+Reset+"\n", arg)
+		return ""
+	case 1:
+		return matches[0]
+	}
+	sort.Strings(matches)
+	return chooseName("person", matches)
+}
+
+// npcVoice returns how an NPC speaks, asking the narrator the first time
+// so their manner stays the same from one conversation to the next
+func npcVoice(name string, info *Npc) string {
+	if info.Voice != "" || info.Kind == "creature" {
+		return info.Voice
+	}
+	voice := strings.Trim(strings.TrimSpace(callOpenAIQuick([]Message{{Role: "user", Content: fmt.Sprintf(
+		"%s: %s Backstory: %s\nIn under ten words, describe how %s speaks: tone, pace and vocabulary "+
+			"(e.g. 'gruff, terse, nautical slang'). Reply with only the description.", name, info.Bio, info.Backstory, name)}})), "'\".")
+	if voice != "" && !isDegraded(voice) && len(strings.Fields(voice)) <= 15 {
+		info.Voice = voice
+	}
+	return info.Voice
+}
+
+// npcCmd handles the npc subcommands: regen <name> and voice <name> [<style>]
+func npcCmd(arg string) {
+	sub, rest, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	rest = strings.TrimSpace(rest)
+	switch {
+	case strings.EqualFold(sub, "regen") && rest != "":
+		regenNpc(rest)
+	case strings.EqualFold(sub, "voice") && rest != "":
+		setNpcVoice(rest)
+	default:
+		fmt.Println("Usage: npc regen <name> | npc voice <name> [<description>]")
+	}
+}
+
+// setNpcVoice shows or overrides an NPC's speaking style. The name is the
+// longest run of leading words naming someone met, else the first word.
+func setNpcVoice(arg string) {
+	words := strings.Fields(arg)
+	name, n := "", 1
+	for i := len(words); i > 0 && name == ""; i-- {
+		for known := range npcData {
+			if strings.EqualFold(known, strings.Join(words[:i], " ")) {
+				name, n = known, i
+			}
+		}
+	}
+	if name == "" {
+		if name = metNpc(words[0]); name == "" {
+			return
+		}
+	}
+	info := npcData[name]
+	voice := strings.Join(words[n:], " ")
+	if voice == "" {
+		if info.Voice == "" {
+			fmt.Printf("%s's voice hasn't been settled yet; it will be in your next conversation.\n", name)
+		} else {
+			fmt.Printf("%s speaks: %s\n", name, info.Voice)
+		}
+		return
+	}
+	info.Voice = voice
+	fmt.Printf(Yellow+"%s now speaks: %s"+Reset+"\n", name, voice)
+}
+
+// regenNpc rewrites an NPC's bio and backstory from recent events, after
+// showing old and new; affinity, gifts and the rest are kept
+func regenNpc(arg string) {
+	name := metNpc(arg)
+	if name == "" {
+		return
+	}
+	info := npcData[name]
+	bio, backstory, _ := npcProfile(name, 20)
+	if bio == "" || backstory == "" {
+		fmt.Println(Red + "The narrator couldn't say more about " + name + " just now." + Reset)
+		return
+	}
+	fmt.Printf(Dim+"Old: %s %s"+Reset+"\n", info.Bio, info.Backstory)
+	fmt.Printf(Blue+"New: %s %s"+Reset+"\n", bio, backstory)
+	if !confirm("Use the new bio for " + name + "?") {
+		return
+	}
+	info.Bio, info.Backstory = bio, backstory
+	fmt.Printf(Yellow+"%s's bio updated."+Reset+"\n", name)
+}
+
+// forgetNpc removes a met NPC after confirmation
+func forgetNpc(arg string) {
+	if arg == "" {
+		fmt.Println("Usage: forget <NPC name>")
+		return
+	}
+	name := metNpc(arg)
+	if name == "" {
+		return
+	}
+	if talkingTo[name] {
+		fmt.Printf(Red+"You can't forget %s while you're talking to them."+Reset+"\n", name)
+		return
+	}
+	if !confirm(fmt.Sprintf("Forget %s and everything about them?", name)) {
+		return
+	}
+	delete(npcData, name)
+	lastNpcs = slices.DeleteFunc(lastNpcs, func(n string) bool { return n == name })
+	fmt.Printf(Yellow+"Forgot %s."+Reset+"\n", name)
+}
+
+// npcPruneDays is how long unseen NPCs are kept by npcs prune by default
+const npcPruneDays = 7
+
+// npcsCmd lists the NPCs met with when each was last seen, or prunes those
+// not seen for some days
+func npcsCmd(arg string) {
+	fields := strings.Fields(strings.ToLower(arg))
+	if len(fields) == 0 {
+		if len(npcData) == 0 {
+			fmt.Println("You haven't met anyone yet.")
+			return
+		}
+		names := make([]string, 0, len(npcData))
+		for n := range npcData {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Println(Blue + "People you've met:" + Reset)
+		for _, n := range names {
+			fmt.Printf(" %s"+Dim+" (last seen day %d)"+Reset+"\n", n, npcData[n].LastSeen)
+		}
+		return
+	}
+	if fields[0] != "prune" || len(fields) > 2 {
+		fmt.Println("Usage: npcs [prune [<days>]]")
+		return
+	}
+	days := npcPruneDays
+	if len(fields) == 2 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 {
+			fmt.Println("Usage: npcs prune [<days>]")
+			return
+		}
+		days = n
+	}
+	var stale []string
+	for n, info := range npcData {
+		if playerState.Day-info.LastSeen > days && !talkingTo[n] && !contains(lastNpcs, n) {
+			stale = append(stale, n)
+		}
+	}
+	if len(stale) == 0 {
+		fmt.Printf("No one has gone unseen for more than %d days.\n", days)
+		return
+	}
+	sort.Strings(stale)
+	if !confirm(fmt.Sprintf("Forget %d not seen for more than %d days (%s)?", len(stale), days, strings.Join(stale, ", "))) {
+		return
+	}
+	for _, n := range stale {
+		delete(npcData, n)
+	}
+	fmt.Printf(Yellow+"Removed %d NPCs."+Reset+"\n", len(stale))
+}
+
+// looksLikeCreature reports whether a scene listing names something rather
+// than someone: "the wolf" or "a barn owl", but not "The Ferryman"
+func looksLikeCreature(name string) bool {
+	rest := name
+	for _, a := range []string{"the ", "a ", "an "} {
+		if len(name) > len(a) && strings.EqualFold(name[:len(a)], a) {
+			rest = name[len(a):]
+			break
+		}
+	}
+	return rest != "" && unicode.IsLower([]rune(rest)[0])
+}
+
+// askAbout opens a conversation with "<name> about <topic>" as its first line
+func askAbout(arg string) {
+	i := strings.Index(strings.ToLower(arg), " about ")
+	if i < 0 {
+		fmt.Println("Usage: ask <name> about <topic>")
+		return
+	}
+	who, topic := strings.TrimSpace(arg[:i]), strings.TrimSpace(arg[i+len(" about "):])
+	if who == "" || topic == "" {
+		fmt.Println("Usage: ask <name> about <topic>")
+		return
+	}
+	if name := resolveNpcName(who); name != "" {
+		startConversation(name, topic)
+	}
+}
+
+// askStays keeps 'ask <name> about <topic>' in the conversation after the
+// first answer, rather than returning to exploration
+var askStays bool
+
+// Start conversation with NPC; a topic is put to them as the first line,
+// skipping the greeting
+func startConversation(npcName, topic string) {
+	_, met := npcData[npcName]
+	info := ensureNpc(npcName)
+	if info.Kind == "creature" {
+		approachCreature(npcName, info)
+		return
+	}
+	sys := fmt.Sprintf("You are %s.\n%s\nBackstory: %s\n\n"+
+		"Speak in first-person as yourself. ALWAYS refer to yourself by that exact name. "+
+		"When the player says 'goodbye' or 'bye', end the conversation politely. "+
+		"If what the player just said warms you toward them, end your reply with [MOOD:+1]; "+
+		"if it puts you off, end it with [MOOD:-1]; otherwise add nothing.",
+		npcName, info.Bio, info.Backstory)
+	if v := npcVoice(npcName, info); v != "" {
+		sys += "\nYour manner of speech: " + v + ". Keep to it."
+	}
+	if pc := playerContext(); pc != "" {
+		sys += "\n\n" + pc
+	}
+	conv := []Message{{Role: "system", Content: sys}}
+	mood := 0
+	talkingTo[npcName] = true
+	defer delete(talkingTo, npcName)
+	fmt.Printf("\n"+Blue+"— You begin talking with %s. —"+Reset+"\n"+Dim+"(%s)"+Reset+"\n\n", npcName, conversationHelp)
+	first := ""
+	if topic != "" {
+		first = fmt.Sprintf("I'd like to ask you about %s.", topic)
+	} else if greeting, _ := parseMood(greetPlayer(conv, info, met)); greeting != "" {
+		fmt.Printf(Green+"%s:"+Reset+" %s\n", npcName, greeting)
+		conv = append(conv, Message{Role: "assistant", Content: greeting})
+	}
+	for {
+		line := first
+		if first != "" {
+			first = ""
+			fmt.Println("You: " + line)
+		} else {
+			if topic != "" && !askStays {
+				fmt.Printf("— You thank %s and return to exploration. —\n\n", npcName)
+				return
+			}
+			fmt.Print("You: ")
+			var err error
+			line, err = readReply()
+			if err != nil && line == "" {
+				fmt.Println()
+				return
+			}
+		}
+		if line == "" {
+			continue
+		}
+		if handled, leave := conversationMeta(line, conv); leave {
+			return
+		} else if handled {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			narratorAside(npcName, info.Bio+" Backstory: "+info.Backstory, conv, line[1:])
+			continue
+		}
+		conv = append(pruneConversation(conv), Message{Role: "user", Content: line})
+		low := strings.ToLower(line)
+		if low == "goodbye" || low == "bye" {
+			farewell, shift := parseMood(callOpenAI(conv))
+			mood += shift
+			fmt.Printf(moodColor(mood)+"%s:"+Reset+" %s\n\n", npcName, farewell)
+			info.Affinity += moodAffinity(mood)
+			fmt.Println("— Conversation ended. You return to exploration. —")
+			fmt.Println()
+			return
+		}
+		var reply string
+		reply, conv = callOpenAIKeep(conv)
+		if isDegraded(reply) && lastCallErr != nil {
+			conv = conv[:len(conv)-1]
+			if errors.Is(lastCallErr, ErrAuth) {
+				fmt.Println(Red + "The API rejected the key; the conversation ends." + Reset)
+				return
+			}
+			fmt.Println(Dim + "(The connection faltered and " + npcName + " didn't hear you. Try saying that again.)" + Reset)
+			continue
+		}
+		reply, shift := parseMood(reply)
+		mood += shift
+		fmt.Printf(moodColor(mood)+"%s:"+Reset+" %s\n", npcName, reply)
+		conv = append(conv, Message{Role: "assistant", Content: reply})
+	}
+}
+
+// moodRe matches the [MOOD:+1] / [MOOD:-1] cue an NPC adds to a reply
+var moodRe = regexp.MustCompile(`\s*\[MOOD:\s*([+-]?\d+)\]`)
+
+// parseMood strips mood cues from a reply and returns their sum, each
+// clamped to a single step
+func parseMood(reply string) (string, int) {
+	shift := 0
+	for _, m := range moodRe.FindAllStringSubmatch(reply, -1) {
+		n, _ := strconv.Atoi(m[1])
+		shift += max(-1, min(1, n))
+	}
+	return strings.TrimSpace(moodRe.ReplaceAllString(reply, "")), shift
+}
+
+// moodColor tints an NPC's name tag once their mood has shifted noticeably
+func moodColor(mood int) string {
+	switch {
+	case mood >= 2:
+		return Yellow
+	case mood <= -2:
+		return Red
+	}
+	return Green
+}
+
+// moodAffinity turns the mood a conversation ended in into an affinity
+// change: a neutral chat still counts for one point
+func moodAffinity(mood int) int {
+	return max(-2, min(3, 1+mood))
+}
+
+// greetPlayer has an NPC open a conversation, warmly or coolly by affinity
+// and remembering earlier meetings and gifts; it returns "" on failure
+func greetPlayer(conv []Message, info *Npc, met bool) string {
+	var b strings.Builder
+	b.WriteString("(The player approaches you. Greet them in one or two sentences, in character.")
+	if met {
+		b.WriteString(" You have met before.")
+	} else {
+		b.WriteString(" This is your first meeting.")
+	}
+	switch {
+	case info.Affinity >= 5:
+		b.WriteString(" You are fond of them.")
+	case info.Affinity > 0:
+		b.WriteString(" You are friendly toward them.")
+	case info.Affinity < 0:
+		b.WriteString(" You are wary of them.")
+	}
+	if len(info.Gifts) > 0 {
+		b.WriteString(" They have given you gifts before.")
+	}
+	b.WriteString(")")
+	greeting := normalizeText(callOpenAI(append(conv, Message{Role: "user", Content: b.String()})))
+	if isDegraded(greeting) {
+		return ""
+	}
+	return greeting
+}
+
+// Name tag colors for speakers in a group conversation
+var speakerColors = []string{Green, Yellow, Magenta, Blue}
+
+// startGroupConversation role-plays several NPCs at once, each reply line
+// labeled by speaker. Each speaker's mood shifts their affinity, and whoever
+// the player addressed most by name also gains a point for the chat.
+func startGroupConversation(names []string) {
+	var roster []string
+	for _, n := range names {
+		info := ensureNpc(n)
+		line := fmt.Sprintf("- %s: %s Backstory: %s", n, info.Bio, info.Backstory)
+		if v := npcVoice(n, info); v != "" {
+			line += " Speaks: " + v + "."
+		}
+		roster = append(roster, line)
+	}
+	sys := "You are role-playing a group conversation between the player and these people:\n" +
+		strings.Join(roster, "\n") + "\n\n" +
+		"Speak only as them, in first person. Start every line of dialogue with the speaker's exact full name and a colon " +
+		"(e.g. '" + names[0] + ": ...'). Whoever the player addresses should answer; others may chime in, but not everyone " +
+		"must speak every turn. When the player says 'goodbye' or 'bye', each bids farewell.\n\n" +
+		"If what the player just said warms a speaker toward them, end that speaker's line with [MOOD:+1]; " +
+		"if it puts them off, end it with [MOOD:-1]; otherwise add nothing."
+	if pc := playerContext(); pc != "" {
+		sys += "\n\n" + pc
+	}
+	conv := []Message{{Role: "system", Content: sys}}
+	for _, n := range names {
+		talkingTo[n] = true
+		defer delete(talkingTo, n)
+	}
+	addressed := map[string]int{}
+	moods := map[string]int{}
+	fmt.Printf("\n"+Blue+"— You join a conversation with %s. —"+Reset+"\n"+Dim+"(address someone by name; %s)"+Reset+"\n\n",
+		strings.Join(names, ", "), conversationHelp)
+	for {
+		fmt.Print("You: ")
+		line, err := readReply()
+		if err != nil && line == "" {
+			fmt.Println()
+			return
+		}
+		if line == "" {
+			continue
+		}
+		if handled, leave := conversationMeta(line, conv); leave {
+			return
+		} else if handled {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			narratorAside(strings.Join(names, ", "), "a group of "+strconv.Itoa(len(names))+" people", conv, line[1:])
+			continue
+		}
+		low := strings.ToLower(line)
+		bye := low == "goodbye" || low == "bye"
+		conv = append(pruneConversation(conv), Message{Role: "user", Content: line})
+		reply, sent := callOpenAIKeep(conv)
+		conv, reply = sent, normalizeText(reply)
+		if isDegraded(reply) && lastCallErr != nil && !bye {
+			conv = conv[:len(conv)-1]
+			if errors.Is(lastCallErr, ErrAuth) {
+				fmt.Println(Red + "The API rejected the key; the conversation ends." + Reset)
+				return
+			}
+			fmt.Println(Dim + "(The connection faltered and no one heard you. Try saying that again.)" + Reset)
+			continue
+		}
+		for _, n := range names {
+			if strings.Contains(low, strings.ToLower(strings.Fields(n)[0])) {
+				addressed[n]++
+			}
+		}
+		reply = printGroupReply(reply, names, moods)
+		conv = append(conv, Message{Role: "assistant", Content: reply})
+		if bye {
+			best := 0
+			for _, n := range names {
+				if addressed[n] > best {
+					best = addressed[n]
+				}
+			}
+			for _, n := range names {
+				// the point for a neutral chat only goes to someone actually named
+				shift := moodAffinity(moods[n])
+				if best == 0 || addressed[n] < best {
+					shift--
+				}
+				npcData[n].Affinity += shift
+			}
+			fmt.Println()
+			fmt.Println("— Conversation ended. You return to exploration. —")
+			fmt.Println()
+			return
+		}
+	}
+}
+
+// printGroupReply prints labeled dialogue lines, coloring each speaker's name
+// tag. Mood cues are added to that speaker's entry in moods, and the reply is
+// returned without them.
+func printGroupReply(reply string, names []string, moods map[string]int) string {
+	lines := strings.Split(reply, "\n")
+	for k, line := range lines {
+		line, shift := parseMood(line)
+		lines[k] = line
+		speaker := -1
+		if i := strings.Index(line, ":"); i > 0 {
+			tag := strings.ToLower(strings.Trim(line[:i], " *"))
+			for j, n := range names {
+				if tag == "" {
+					break
+				}
+				ln := strings.ToLower(n)
+				if tag == ln || strings.HasPrefix(ln, tag) || strings.HasPrefix(tag, strings.ToLower(strings.Fields(n)[0])) {
+					speaker = j
+					break
+				}
+			}
+			if speaker >= 0 {
+				moods[names[speaker]] += shift
+				color := speakerColors[speaker%len(speakerColors)]
+				if m := moods[names[speaker]]; m >= 2 || m <= -2 {
+					color = moodColor(m)
+				}
+				fmt.Printf(color+"%s:"+Reset+"%s\n", strings.Trim(line[:i], " *"), line[i+1:])
+				continue
+			}
+		}
+		fmt.Println(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// approachCreature is startConversation for creatures: the narrator answers
+// with the creature's actions and sounds rather than dialogue
+func approachCreature(name string, info *Npc) {
+	sys := fmt.Sprintf("You are narrating %s, a creature the player is trying to interact with.\n%s\nHabits: %s\n\n"+
+		"It does not speak unless this world truly gives it a voice. Answer each thing the player says or does "+
+		"with the creature's reaction in third person (movement, sounds, body language) in one or two sentences. "+
+		"Its trust grows or shrinks with how the player treats it.",
+		name, info.Bio, info.Backstory)
+	if pc := playerContext(); pc != "" {
+		sys += "\n\n" + pc
+	}
+	conv := []Message{{Role: "system", Content: sys}}
+	fmt.Printf("\n"+Blue+"— You approach %s. —"+Reset+"\n"+Dim+"('goodbye' or 'bye' to leave it be, /quit to back away at once)"+Reset+"\n\n", name)
+	for {
+		fmt.Print("You: ")
+		line, err := readReply()
+		if err != nil && line == "" {
+			fmt.Println()
+			return
+		}
+		if line == "" {
+			continue
+		}
+		if handled, leave := conversationMeta(line, conv); leave {
+			return
+		} else if handled {
+			continue
+		}
+		conv = append(pruneConversation(conv), Message{Role: "user", Content: line})
+		low := strings.ToLower(line)
+		if low == "goodbye" || low == "bye" {
+			conv[len(conv)-1].Content = "The player leaves the creature be."
+			fmt.Println(Dim + callOpenAI(conv) + Reset)
+			info.Affinity++
+			fmt.Println("— You leave it be and return to exploration. —")
+			fmt.Println()
+			return
+		}
+		var reply string
+		reply, conv = callOpenAIKeep(conv)
+		fmt.Println(Dim + reply + Reset)
+		conv = append(conv, Message{Role: "assistant", Content: reply})
+	}
+}
+
+// conversationHelp lists what can be typed in a conversation besides dialogue
+const conversationHelp = "'goodbye' or 'bye' to take your leave, /quit to walk away at once, " +
+	"/summary to recall what's been said, /<question> to ask the narrator privately, /help for this list"
+
+// conversationMeta handles the conversation's meta-commands: /quit leaves
+// just the conversation, and a bare quit or exit, which would otherwise be
+// said aloud, explains how to leave instead of ending the game
+func conversationMeta(line string, conv []Message) (handled, leave bool) {
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "/summary":
+		summarizeConversation(conv)
+		return true, false
+	case "/quit", "/leave":
+		fmt.Println("— You walk away from the conversation. —")
+		fmt.Println()
+		return true, true
+	case "/help":
+		fmt.Println(Dim + "(" + conversationHelp + ")" + Reset)
+		return true, false
+	case "quit", "exit", "stop", "/exit":
+		fmt.Println(Dim + "(To leave the conversation type /quit or say goodbye; quit the game from the main prompt afterwards.)" + Reset)
+		return true, false
+	}
+	return false, false
+}
+
+// summarizeConversation prints a summary of the dialogue so far without
+// adding it to the conversation
+func summarizeConversation(conv []Message) {
+	var dialogue []Message
+	for _, m := range conv {
+		// the NPC's own instructions are not part of what was said
+		if m.Role != "system" || isSummary(m) {
+			dialogue = append(dialogue, m)
+		}
+	}
+	if len(dialogue) == 0 {
+		fmt.Println(Dim + "(Nothing has been said yet.)" + Reset)
+		return
+	}
+	prompt := append([]Message{{Role: "system", Content: summaryPrompt}}, dialogue...)
+	prompt = append(prompt, Message{Role: "user", Content: "Summarize this conversation so far: who said what, and anything agreed or left open."})
+	summary := normalizeText(callOpenAIQuick(prompt))
+	fmt.Println(Dim + summary + Reset)
+}
+
+// narratorAside answers an out-of-character question mid-conversation
+// without the NPCs hearing it or it entering the dialogue
+func narratorAside(who, about string, conv []Message, question string) {
+	question = strings.TrimSpace(question)
+	if low := strings.ToLower(question); strings.HasPrefix(low, "ask ") {
+		question = strings.TrimSpace(question[4:])
+	}
+	if question == "" {
+		fmt.Println(Magenta + "Ask the narrator something, e.g. /ask what do I know about this person?" + Reset)
+		return
+	}
+	var dialogue []string
+	for _, m := range conv[1:] {
+		if m.Role == "user" {
+			dialogue = append(dialogue, "Player: "+m.Content)
+		} else {
+			dialogue = append(dialogue, m.Content)
+		}
+	}
+	if len(dialogue) > 10 {
+		dialogue = dialogue[len(dialogue)-10:]
+	}
+	aside := fmt.Sprintf("The player is mid-conversation with %s (%s).\n"+
+		"Conversation so far:\n%s\n\n"+
+		"As the narrator, answer the player's private, out-of-character question briefly, "+
+		"using only what their character could plausibly know. %s cannot hear this.",
+		who, about, strings.Join(dialogue, "\n"), who)
+	prompt := append(history, Message{Role: "system", Content: aside}, Message{Role: "user", Content: question})
+	answer := normalizeText(callOpenAI(prompt))
+	fmt.Println(Magenta + "(Narrator) " + answer + Reset)
+}
+
+// Draw ASCII map recursively
+func drawMap(node, parent, prefix string, isLast bool, visited map[string]bool) {
+	if visited == nil {
+		visited = map[string]bool{node: true}
+		fmt.Println(prefix + node)
+	} else {
+		branch := "├─ "
+		if isLast {
+			branch = "└─ "
+		}
+		fmt.Println(prefix + branch + node)
+		visited[node] = true
+	}
+	neighbors := make([]string, 0)
+	for n := range playerState.MapGraph[node] {
+		neighbors = append(neighbors, n)
+	}
+	sort.Strings(neighbors)
+	children := make([]string, 0)
+	for _, c := range neighbors {
+		if c == parent || visited[c] {
+			continue
+		}
+		children = append(children, c)
+	}
+	// unexplored places are dim leaves after the visited children
+	frontiers := make([]string, 0)
+	for f := range playerState.Frontiers[node] {
+		if !contains(playerState.VisitedLocations, f) && !playerState.MapGraph[node][f] {
+			frontiers = append(frontiers, f)
+		}
+	}
+	sort.Strings(frontiers)
+	nextPrefix := prefix
+	if isLast {
+		nextPrefix += "   "
+	} else {
+		nextPrefix += "│  "
+	}
+	for i, child := range children {
+		last := i == len(children)-1 && len(frontiers) == 0
+		drawMap(child, node, nextPrefix, last, visited)
+	}
+	for i, f := range frontiers {
+		branch := "├─ "
+		if i == len(frontiers)-1 {
+			branch = "└─ "
+		}
+		fmt.Println(nextPrefix + branch + Dim + "? " + f + Reset)
+	}
+}
+
+// perceptionMod returns the better of the WIS and INT modifiers
+func perceptionMod() int {
+	best := (statValue("WIS") - 10) / 2
+	if m := (statValue("INT") - 10) / 2; m > best {
+		best = m
+	}
+	return best
+}
+
+// askDC asks the model for a difficulty class, defaulting to 15
+func askDC(msgs []Message, action string) int {
+	prompt := append(msgs, Message{Role: "user", Content: fmt.Sprintf(
+		"The player attempts to %s. How hard is this? Reply with only a difficulty class number between 5 and 25.", action)})
+	raw := callOpenAIQuick(prompt)
+	dc := 15
+	for _, f := range strings.Fields(raw) {
+		if n, err := strconv.Atoi(strings.Trim(f, ".!?:;,")); err == nil {
+			dc = n
+			break
+		}
+	}
+	if dc < 5 {
+		dc = 5
+	}
+	if dc > 25 {
+		dc = 25
+	}
+	return dc
+}
+
+// searchArea rolls Perception to uncover a hidden item or passage
+func searchArea(cmd, area string) {
+	loc := playerState.CurrentLocation
+	dc, note := adjustDC(askDC(history, "search "+area+" for anything hidden"))
+	mod := perceptionMod()
+	die := rng.Intn(20) + 1
+	total := die + mod
+	outcome := "Failure"
+	if total >= dc {
+		outcome = "Success"
+	}
+	fmt.Println(Yellow + fmt.Sprintf("Perception check: rolled 1d20 + %d = %d vs DC %d%s: %s", mod, total, dc, note, outcome) + Reset)
+	history = append(history, Message{Role: "user", Content: cmd})
+	if total < dc {
+		prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+			"The player searched %s but found nothing hidden. Briefly narrate the search turning up nothing notable.", area)})
+		desc := normalizeText(callOpenAI(prompt))
+		fmt.Println(Blue + desc + Reset)
+		recordReply(desc)
+		return
+	}
+	prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+		"The player searched %s and succeeded. Describe what they discover: either a hidden item or a concealed passage.\n"+
+			"End with exactly one line in the form 'FOUND: ITEM: <name>' or 'FOUND: PASSAGE: <destination name>'.", area)})
+	raw := normalizeText(callOpenAI(prompt))
+	kind, name := "", ""
+	var lines []string
+	for _, line := range strings.Split(raw, "\n") {
+		up := strings.ToUpper(strings.TrimSpace(line))
+		if strings.HasPrefix(up, "FOUND:") {
+			rest := strings.TrimSpace(strings.TrimSpace(line)[6:])
+			if i := strings.Index(rest, ":"); i >= 0 {
+				kind = strings.ToUpper(strings.TrimSpace(rest[:i]))
+				name = strings.Trim(strings.TrimSpace(rest[i+1:]), ".!?;\"'")
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	desc := normalizeText(strings.Join(lines, "\n"))
+	fmt.Println(Blue + desc + Reset)
+	recordReply(desc)
+	if name == "" {
+		return
+	}
+	switch kind {
+	case "PASSAGE":
+		dest := titleCase(name)
+		if loc != "" {
+			if playerState.MapGraph[loc] == nil {
+				playerState.MapGraph[loc] = map[string]bool{}
+			}
+			if playerState.MapGraph[dest] == nil {
+				playerState.MapGraph[dest] = map[string]bool{}
+			}
+			playerState.MapGraph[loc][dest] = true
+			playerState.MapGraph[dest][loc] = true
+		}
+		fmt.Printf(Green+"You discovered a hidden passage to %s."+Reset+"\n", dest)
+		playerState.Journal = append(playerState.Journal, fmt.Sprintf("Discovered a hidden passage to %s while searching %s.", dest, area))
+	default:
+		if playerState.SceneItems == nil {
+			playerState.SceneItems = map[string][]string{}
+		}
+		playerState.SceneItems[loc] = append(playerState.SceneItems[loc], name)
+		fmt.Printf(Green+"You found: %s"+Reset+"\n", name)
+		playerState.Journal = append(playerState.Journal, fmt.Sprintf("Found %s while searching %s.", name, area))
+	}
+}
+
+// addItem puts an item in the inventory
+func addItem(name string) {
+	if i := playerState.Inventory.find(name); i >= 0 {
+		playerState.Inventory[i].Qty++
+		return
+	}
+	cat := playerState.Categories[strings.ToLower(name)]
+	if cat == "" {
+		cat = "Misc"
+	}
+	playerState.Inventory = append(playerState.Inventory, InvItem{Name: name, Qty: 1, Category: cat})
+}
+
+// Item categories, in the order the inventory lists them
+var itemCategories = []string{"Weapon", "Consumable", "Key Item", "Misc"}
+
+// askCategory asks the narrator to classify an item, caching the answer per
+// item name
+func askCategory(name string) string {
+	key := strings.ToLower(name)
+	if cat, ok := playerState.Categories[key]; ok {
+		return cat
+	}
+	prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+		"Classify the %s as one of: %s. Reply with only the category.", name, strings.Join(itemCategories, ", "))})
+	raw := strings.ToLower(callOpenAIQuick(prompt))
+	cat := "Misc"
+	for _, c := range itemCategories {
+		if strings.Contains(raw, strings.ToLower(c)) {
+			cat = c
+			break
+		}
+	}
+	playerState.Categories[key] = cat
+	return cat
+}
+
+// showInventory lists items grouped by category, optionally only those in
+// categories starting with filter
+func showInventory(filter string) {
+	filter = strings.ToLower(filter)
+	verbose := filter == "-v" || strings.HasPrefix(filter, "-v ")
+	if verbose {
+		filter = strings.TrimSpace(filter[2:])
+	}
+	shown := 0
+	for _, cat := range itemCategories {
+		if filter != "" && !strings.HasPrefix(strings.ToLower(cat), filter) {
+			continue
+		}
+		var stack Inventory
+		for _, it := range playerState.Inventory {
+			c := it.Category
+			if c == "" {
+				c = "Misc"
+			}
+			if c == cat {
+				stack = append(stack, it)
+			}
+		}
+		if len(stack) == 0 {
+			continue
+		}
+		shown++
+		descs := make([]string, len(stack))
+		described := false
+		for i, it := range stack {
+			if verbose && itemDescription(it.Name) == "" {
+				describeItem(it.Name)
+			}
+			if descs[i] = briefDescription(itemDescription(it.Name)); descs[i] != "" {
+				described = true
+			}
+		}
+		if !described {
+			fmt.Printf(Magenta+"%s:"+Reset+" %s\n", cat, stack)
+			continue
+		}
+		fmt.Printf(Magenta+"%s:"+Reset+"\n", cat)
+		for i, it := range stack {
+			line := "  " + Inventory{it}.String()
+			if descs[i] != "" {
+				line += Dim + " - " + descs[i] + Reset
+			}
+			fmt.Println(line)
+		}
+	}
+	if shown == 0 {
+		if filter != "" {
+			fmt.Printf("You carry nothing in that category. Categories: %s\n", strings.Join(itemCategories, ", "))
+			return
+		}
+		fmt.Println(Yellow + tr("Inventory:") + Reset + " " + tr("Empty"))
+	}
+	if filter != "" {
+		return
+	}
+	load := fmt.Sprintf("%d/%d", carriedWeight(), playerState.Capacity)
+	if encumbered() {
+		load += Red + " " + tr("(over-encumbered: -2 to STR and DEX checks)") + Reset
+	}
+	fmt.Println(Yellow + tr("Load:") + Reset + " " + load)
+	fmt.Println(Yellow+tr("Gold:")+Reset, playerState.Gold)
+}
+
+// itemDescription returns what is known of an item from examining it, or ""
+func itemDescription(name string) string {
+	if d, ok := itemsData[name]; ok {
+		return d
+	}
+	for k, d := range itemsData {
+		if strings.EqualFold(k, name) {
+			return d
+		}
+	}
+	return ""
+}
+
+// briefDescription cuts a description down to its first sentence
+func briefDescription(desc string) string {
+	desc = strings.TrimSpace(strings.SplitN(strings.TrimSpace(desc), "\n", 2)[0])
+	if i := strings.IndexAny(desc, ".!?"); i >= 0 {
+		desc = desc[:i+1]
+	}
+	if r := []rune(desc); len(r) > 80 {
+		desc = string(r[:79]) + "…"
+	}
+	return desc
+}
+
+// describeItem asks for a one-line description of a carried item and keeps
+// it with the examined items
+func describeItem(name string) {
+	prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+		"In one short sentence, describe the %s the player is carrying. Reply with only the sentence.", name)})
+	desc := normalizeText(callOpenAIQuick(prompt))
+	if desc != "" && !isDegraded(desc) {
+		itemsData[name] = desc
+	}
+}
+
+// InvItem is a stack of identical items
+type InvItem struct {
+	Name     string `json:"name"`
+	Qty      int    `json:"qty"`
+	Category string `json:"category,omitempty"`
+}
+
+// Inventory is the player's item stacks, in the order first picked up
+type Inventory []InvItem
+
+// UnmarshalJSON also accepts the flat list of names used by older saves
+func (inv *Inventory) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err == nil {
+		*inv = Inventory{}
+		for _, n := range names {
+			if i := inv.find(n); i >= 0 {
+				(*inv)[i].Qty++
+			} else {
+				*inv = append(*inv, InvItem{Name: n, Qty: 1})
+			}
+		}
+		return nil
+	}
+	var items []InvItem
+	if err := json.Unmarshal(b, &items); err != nil {
+		return err
+	}
+	*inv = items
+	return nil
+}
+
+// find returns the index of the stack matching name, or -1
+func (inv Inventory) find(name string) int {
+	for i, it := range inv {
+		if strings.EqualFold(it.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// String lists the stacks as "torch x3, rope"
+func (inv Inventory) String() string {
+	var parts []string
+	for _, it := range inv {
+		if it.Qty > 1 {
+			parts = append(parts, fmt.Sprintf("%s x%d", it.Name, it.Qty))
+		} else {
+			parts = append(parts, it.Name)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// baseCapacity derives carrying capacity from STR
+func baseCapacity() int {
+	return statValue("STR") * 2
+}
+
+// itemWeight is an item's remembered weight, defaulting to 1
+func itemWeight(name string) int {
+	if w, ok := playerState.Weights[strings.ToLower(name)]; ok {
+		return w
+	}
+	return 1
+}
+
+// carriedWeight totals the weight of the inventory
+func carriedWeight() int {
+	total := 0
+	for _, it := range playerState.Inventory {
+		total += itemWeight(it.Name) * it.Qty
+	}
+	return total
+}
+
+// encumbered reports whether the player carries more than their capacity
+func encumbered() bool {
+	return carriedWeight() > playerState.Capacity
+}
+
+// askWeight asks the narrator how heavy an item is, on a 1-10 scale, and
+// remembers the answer
+func askWeight(name string) int {
+	key := strings.ToLower(name)
+	if w, ok := playerState.Weights[key]; ok {
+		return w
+	}
+	prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+		"How heavy is the %s to carry, from 1 (a coin or letter) to 10 (a suit of plate armor)? Reply with only the number.", name)})
+	raw := callOpenAIQuick(prompt)
+	w := 1
+	for _, f := range strings.Fields(raw) {
+		if n, err := strconv.Atoi(strings.Trim(f, ".!?:;,")); err == nil {
+			w = max(1, min(n, 10))
+			break
+		}
+	}
+	playerState.Weights[key] = w
+	return w
+}
+
+// dropItem leaves an inventory item in the current scene
+func dropItem(cmd, target string) {
+	if target == "" {
+		fmt.Println("Usage: drop <item>")
+		return
+	}
+	name := removeItem(target)
+	if name == "" {
+		fmt.Printf(Red+"You aren't carrying '%s'."+Reset+"\n", target)
+		return
+	}
+	loc := playerState.CurrentLocation
+	playerState.SceneItems[loc] = append(playerState.SceneItems[loc], name)
+	history = append(history, Message{Role: "user", Content: cmd}, Message{Role: "assistant", Content: fmt.Sprintf("You drop the %s.", name)})
+	fmt.Printf(Yellow+"You drop the %s. (%d/%d)"+Reset+"\n", name, carriedWeight(), playerState.Capacity)
+}
+
+// removeItem takes one of the inventory item matching name, removing the
+// stack when it runs out; it returns the stored name or "" if there was none
+func removeItem(name string) string {
+	inv := playerState.Inventory
+	i := inv.find(name)
+	if i < 0 {
+		return ""
+	}
+	it := inv[i].Name
+	inv[i].Qty--
+	if inv[i].Qty <= 0 {
+		playerState.Inventory = append(inv[:i:i], inv[i+1:]...)
+	}
+	return it
+}
+
+// useItem spends one of an inventory item and narrates the result
+func useItem(item string) {
+	if item == "" {
+		fmt.Println("Usage: use <item>")
+		return
+	}
+	name := removeItem(item)
+	if name == "" {
+		fmt.Printf(Red+"You aren't carrying '%s'."+Reset+"\n", item)
+		return
+	}
+	narrateTurnChanges(fmt.Sprintf("I use the %s.\n(One %s has been used up and is already removed from the inventory; "+
+		"narrate its effect and emit no [INV-] marker for it.)", name, name))
+}
+
+// takeItem moves a found or visible scene item into the inventory
+func takeItem(cmd, target string) {
+	loc := playerState.CurrentLocation
+	name := ""
+	idx := -1
+	found := playerState.SceneItems[loc]
+	for i, it := range found {
+		if strings.EqualFold(it, target) {
+			name, idx = it, i
+			break
+		}
+	}
+	if name == "" {
+		for _, it := range listItems(history) {
+			if strings.EqualFold(it, target) || strings.Contains(strings.ToLower(it), strings.ToLower(target)) {
+				name = it
+				break
+			}
+		}
+	}
+	if name == "" {
+		fmt.Printf(Red+"You don't see '%s' here."+Reset+"\n", target)
+		return
+	}
+	if w := askWeight(name); carriedWeight()+w > playerState.Capacity {
+		fmt.Printf(Red+"The %s is too heavy to add to your load (%d/%d, it weighs %d). Try dropping something first."+Reset+"\n",
+			name, carriedWeight(), playerState.Capacity, w)
+		return
+	}
+	if idx >= 0 {
+		playerState.SceneItems[loc] = append(found[:idx:idx], found[idx+1:]...)
+	}
+	askCategory(name)
+	addItem(name)
+	playerState.Journal = append(playerState.Journal, fmt.Sprintf("Took %s.", name))
+	history = append(history, Message{Role: "user", Content: cmd}, Message{Role: "assistant", Content: fmt.Sprintf("You take the %s.", name)})
+	fmt.Printf(Yellow+"You take the %s."+Reset+"\n", name)
+}
+
+// TranscriptEntry is one JSON line of a session transcript
+type TranscriptEntry struct {
+	Kind     string `json:"kind"` // "start", "command" or "reply"
+	Input    string `json:"input"`
+	Class    string `json:"class,omitempty"`
+	Response string `json:"response,omitempty"`
+}
+
+// logTranscript appends an entry to the transcript file, if one is open
+func logTranscript(e TranscriptEntry) {
+	if transcriptFile == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	transcriptFile.Write(append(b, '\n'))
+}
+
+// logCommand records a dispatched command and any narration it produced
+func logCommand(cmd string, histLen int) {
+	e := TranscriptEntry{Kind: "command", Input: cmd, Class: commandClass(cmd)}
+	if len(history) > histLen && history[len(history)-1].Role == "assistant" {
+		e.Response = history[len(history)-1].Content
+	}
+	logTranscript(e)
+}
+
+// commandClass names how a raw line of input will be handled
+func commandClass(cmd string) string {
+	return parseCommand(expandAlias(cmd)).Verb.String()
+}
+
+// replay re-issues the commands of a transcript non-interactively,
+// feeding recorded replies (such as conversation lines) back as input
+func replay(path string, stopOnDiff bool) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var entries []TranscriptEntry
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e TranscriptEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return fmt.Errorf("bad transcript line: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	var replies []string
+	start := defaultStart
+	for _, e := range entries {
+		switch e.Kind {
+		case "reply":
+			replies = append(replies, e.Input)
+		case "start":
+			start = e.Input
+		}
+	}
+	input = bufio.NewReader(strings.NewReader(strings.Join(replies, "\n") + "\n"))
+	initPlayerState()
+	createCharacter()
+	beginAdventure(start)
+	for _, e := range entries {
+		if e.Kind != "command" {
+			continue
+		}
+		fmt.Printf("\n%s> %s\n", playerState.CurrentLocation, e.Input)
+		if class := commandClass(e.Input); e.Class != "" && class != e.Class {
+			fmt.Printf(Red+"[replay] '%s' now classifies as %s (recorded: %s)"+Reset+"\n", e.Input, class, e.Class)
+			if stopOnDiff {
+				return fmt.Errorf("classification of %q differs", e.Input)
+			}
+		}
+		if _, err := dispatch(e.Input); err == errQuit {
+			break
+		}
+	}
+	return nil
+}
+
+// chooseStart returns the start scene from -start or -quick-start, or asks
+// for one and remembers it in .advrc for the next -quick-start
+func chooseStart() string {
+	switch {
+	case startSet:
+		if strings.TrimSpace(startScene) == "" {
+			return defaultStart
+		}
+		return startScene
+	case quickStart:
+		if saved := rcSettings["start"]; saved != "" {
+			return saved
+		}
+		return defaultStart
+	}
+	fmt.Println("First, choose when and where your story begins (e.g. Year 1372, Isle of Everdawn)")
+	fmt.Print("> ")
+	start, _ := readLine()
+	if start == "" {
+		return defaultStart
+	}
+	if rcSettings["start"] != start {
+		rcSettings["start"] = start
+		if err := saveRC(); err != nil {
+			fmt.Fprintln(os.Stderr, "Config write error:", err)
+		}
+	}
+	return start
+}
+
+// createCharacter asks for the player's name and a one-line self-description
+func createCharacter() {
+	fmt.Println("What is your name, traveler? (leave blank to stay nameless)")
+	fmt.Print("> ")
+	playerState.Name, _ = readReply()
+	fmt.Println("Describe yourself in a line (e.g. a scarred sellsword in a patched green cloak)")
+	fmt.Print("> ")
+	playerState.Description, _ = readReply()
+	chooseClass()
+}
+
+// describePlayer prints the player's appearance, generating and caching it once
+func describePlayer() {
+	if playerState.Appearance == "" {
+		var stats []string
+		for _, k := range playerState.StatNames {
+			stats = append(stats, fmt.Sprintf("%s %d", k, playerState.Stats[k]))
+		}
+		inv := "nothing of note"
+		if len(playerState.Inventory) > 0 {
+			inv = playerState.Inventory.String()
+		}
+		desc := playerState.Description
+		if desc == "" {
+			desc = "(none given)"
+		}
+		prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+			"Describe how the player character looks to others in two or three sentences, in second person.\n"+
+				"Name: %s\nSelf-description: %s\nStats (8-18, 10 is average): %s\nCarrying: %s\n"+
+				"Let the stats shape their build and bearing. Do not mention numbers.",
+			playerState.Name, desc, strings.Join(stats, ", "), inv)})
+		resp := normalizeText(callOpenAI(prompt))
+		if isDegraded(resp) {
+			fmt.Println(Blue + resp + Reset)
+			return
+		}
+		playerState.Appearance = resp
+	}
+	title := "You"
+	if playerState.Name != "" {
+		title = playerState.Name
+	}
+	fmt.Printf(Green+"%s:"+Reset+"\n", title)
+	fmt.Println(Blue + playerState.Appearance + Reset)
+}
+
+// failedNarration reports whether the model produced nothing usable
+func failedNarration(text string) bool {
+	return text == "" || isDegraded(text)
+}
+
+// isDegraded reports whether text is one of the stand-in messages used when
+// the model gives no narration
+func isDegraded(text string) bool {
+	return text == placeholderResponse || text == emptyResponse || text == filteredResponse
+}
+
+// applyMessageSettings lets .advrc override the degradation messages
+func applyMessageSettings() {
+	for key, msg := range map[string]*string{
+		"message-network": &placeholderResponse,
+		"message-empty":   &emptyResponse,
+		"message-filter":  &filteredResponse,
+	} {
+		if v := rcSettings[key]; v != "" {
+			*msg = v
+		}
+	}
+}
+
+// beginAdventure seeds history with the opening scene at start
+func beginAdventure(start string) {
+	logTranscript(TranscriptEntry{Kind: "start", Input: start})
+	fmt.Println(Blue + "…Very well. Setting the scene…" + Reset)
+	fmt.Println()
+	history = []Message{{Role: "system", Content: systemPrompt}, {Role: "user", Content: "Begin the adventure: " + start}}
+	intro := normalizeText(callOpenAIOnHistory())
+	for failedNarration(intro) && confirm("The opening scene failed to generate. Try again?") {
+		intro = normalizeText(callOpenAIOnHistory())
+	}
+	fmt.Println(Blue + intro + Reset)
+	recordReply(intro)
+	playerState.CurrentLocation = start
+	addToPath(start)
+	if !failedNarration(intro) {
+		sceneDescriptions[start] = intro
+		printAmbient(start)
+	}
+	playerState.VisitedLocations = append(playerState.VisitedLocations, start)
+}
+
+// notePrefix marks journal entries the player wrote themselves
+const notePrefix = "NOTE: "
+
+// confirm asks a yes/no question, defaulting to no
+func confirm(question string) bool {
+	fmt.Print(Yellow + question + " " + tr("(y/n)") + " " + Reset)
+	ans, _ := readReply()
+	ans = strings.ToLower(ans)
+	return ans == "y" || ans == "yes" || ans == tr("y") || ans == tr("yes")
+}
+
+// findMerchant asks the narrator who, if anyone, is trading in the scene
+func findMerchant() string {
+	prompt := append(history, Message{Role: "user", Content: "Is there a merchant, shopkeeper or trader present in this scene " +
+		"willing to trade with the player right now? Reply with only their FULL NAME, or 'None'."})
+	name := strings.Trim(strings.TrimSpace(callOpenAIQuick(prompt)), ".!?:;\"")
+	if name == "" || strings.EqualFold(name, "none") || strings.HasPrefix(name, "[") {
+		return ""
+	}
+	return name
+}
+
+// askPrice asks the narrator for an item's fair price in gold, then shifts it
+// 5% per point of the merchant's affinity toward the player (at most 50%)
+func askPrice(merchant, item string, selling bool) int {
+	prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+		"What would %s consider a fair price in gold coins for %s? Reply with only a whole number.", merchant, item)})
+	raw := callOpenAIQuick(prompt)
+	price := 0
+	for _, f := range strings.Fields(raw) {
+		if n, err := strconv.Atoi(strings.Trim(f, ".!?:;,")); err == nil {
+			price = n
+			break
+		}
+	}
+	if price <= 0 {
+		return 0
+	}
+	shift := 5 * max(-10, min(npcData[merchant].Affinity, 10))
+	if selling {
+		// Merchants buy at half price, more generously for friends
+		return max(1, price*(100+shift)/200)
+	}
+	return max(1, price*(100-shift)/100)
+}
+
+// buyItem purchases an item from a merchant in the scene
+func buyItem(item string) {
+	if item == "" {
+		fmt.Println("Usage: buy <item>")
+		return
+	}
+	merchant := findMerchant()
+	if merchant == "" {
+		fmt.Println("There's no one here to buy from.")
+		return
+	}
+	ensureNpc(merchant)
+	price := askPrice(merchant, item, false)
+	if price == 0 {
+		fmt.Println(merchant + " doesn't seem to have that for sale.")
+		return
+	}
+	if playerState.Gold < price {
+		fmt.Printf(Red+"%s wants %d gold for %s, but you only have %d."+Reset+"\n", merchant, price, item, playerState.Gold)
+		return
+	}
+	if !confirm(fmt.Sprintf("%s offers %s for %d gold. Buy it?", merchant, item, price)) {
+		return
+	}
+	playerState.Gold -= price
+	askCategory(item)
+	addItem(item)
+	fmt.Printf(Yellow+"[Bought %s for %d gold (%d left)]"+Reset+"\n", item, price, playerState.Gold)
+	narrateTurn(fmt.Sprintf("I buy %s from %s for %d gold.\n(The trade is already recorded; describe the exchange briefly and emit no markers.)", item, merchant, price))
+}
+
+// sellItem sells an inventory item to a merchant in the scene
+func sellItem(item string) {
+	if item == "" {
+		fmt.Println("Usage: sell <item>")
+		return
+	}
+	var owned string
+	if i := playerState.Inventory.find(item); i >= 0 {
+		owned = playerState.Inventory[i].Name
+	}
+	if owned == "" {
+		fmt.Println("You don't have " + item + ".")
+		return
+	}
+	merchant := findMerchant()
+	if merchant == "" {
+		fmt.Println("There's no one here to sell to.")
+		return
+	}
+	ensureNpc(merchant)
+	price := askPrice(merchant, owned, true)
+	if price == 0 {
+		fmt.Println(merchant + " isn't interested in " + owned + ".")
+		return
+	}
+	if !confirm(fmt.Sprintf("%s offers %d gold for %s. Sell it?", merchant, price, owned)) {
+		return
+	}
+	removeItem(owned)
+	playerState.Gold += price
+	fmt.Printf(Yellow+"[Sold %s for %d gold (%d total)]"+Reset+"\n", owned, price, playerState.Gold)
+	narrateTurn(fmt.Sprintf("I sell %s to %s for %d gold.\n(The trade is already recorded; describe the exchange briefly and emit no markers.)", owned, merchant, price))
+}
+
+// giftAffinity is the affinity a gift earns by how many of its category the
+// NPC already has: the first is worth 3, each repeat one less, down to nothing
+func giftAffinity(given int) int {
+	return max(0, 3-given)
+}
+
+// giveItem hands an inventory item to an NPC, who warms to the player less
+// with each gift of the same kind
+func giveItem(arg string) {
+	i := strings.LastIndex(strings.ToLower(arg), " to ")
+	if i < 0 {
+		fmt.Println("Usage: give <item> to <person>")
+		return
+	}
+	item, who := strings.TrimSpace(arg[:i]), strings.TrimSpace(arg[i+len(" to "):])
+	j := playerState.Inventory.find(item)
+	if j < 0 {
+		fmt.Printf(Red+"You aren't carrying '%s'."+Reset+"\n", item)
+		return
+	}
+	name := resolveNpcName(who)
+	if name == "" {
+		return
+	}
+	npc := ensureNpc(name)
+	cat := playerState.Inventory[j].Category
+	if cat == "" {
+		cat = "Misc"
+	}
+	item = removeItem(item)
+	if npc.Gifts == nil {
+		npc.Gifts = map[string]int{}
+	}
+	given := npc.Gifts[cat]
+	gain := giftAffinity(given)
+	npc.Gifts[cat]++
+	npc.Affinity += gain
+	var reaction string
+	switch {
+	case given == 0:
+		reaction = "This is the first gift of its kind from the player; they are pleased."
+	case gain > 0:
+		reaction = fmt.Sprintf("The player has given them %d such gifts (%s) before; they are less impressed than the first time.", given, strings.ToLower(cat))
+	default:
+		reaction = fmt.Sprintf("The player has already given them %d such gifts (%s); they have had enough of them and may say so.", given, strings.ToLower(cat))
+	}
+	fmt.Printf(Yellow+"[Gave %s to %s, affinity %+d]"+Reset+"\n", item, name, gain)
+	playerState.Journal = append(playerState.Journal, fmt.Sprintf("Gave %s to %s.", item, name))
+	narrateTurn(fmt.Sprintf("I give the %s to %s.\n(The gift is already recorded; emit no markers. %s Describe %s's reaction.)", item, name, reaction, name))
+}
+
+// addNote records a freeform player note in the journal
+func addNote(text string) {
+	if text == "" {
+		fmt.Println("Usage: note <text>")
+		return
+	}
+	playerState.Journal = append(playerState.Journal, notePrefix+text)
+	fmt.Println(Yellow + "Noted." + Reset)
+}
+
+// journalCmd shows the journal, the last n entries, or adds/clears notes
+func journalCmd(arg string) {
+	low := strings.ToLower(arg)
+	switch {
+	case low == "clear":
+		if len(playerState.Journal) == 0 {
+			fmt.Println("Your journal is already empty.")
+		} else if confirm(fmt.Sprintf("Erase all %d journal entries?", len(playerState.Journal))) {
+			playerState.Journal = []string{}
+			fmt.Println(Yellow + "Journal cleared." + Reset)
+		}
+		return
+	case low == "add" || strings.HasPrefix(low, "add "):
+		addNote(strings.TrimSpace(arg[3:]))
+		return
+	case low == "delete" || strings.HasPrefix(low, "delete "):
+		i, ok := journalIndex(strings.TrimSpace(arg[6:]))
+		if !ok {
+			return
+		}
+		removed := playerState.Journal[i]
+		playerState.Journal = append(playerState.Journal[:i], playerState.Journal[i+1:]...)
+		fmt.Printf(Yellow+"Deleted entry %d: %s"+Reset+"\n", i+1, strings.TrimPrefix(removed, notePrefix))
+		return
+	case low == "edit" || strings.HasPrefix(low, "edit "):
+		parts := strings.SplitN(strings.TrimSpace(arg[4:]), " ", 2)
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+			fmt.Println("Usage: journal edit <n> <text>")
+			return
+		}
+		i, ok := journalIndex(parts[0])
+		if !ok {
+			return
+		}
+		text := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(playerState.Journal[i], notePrefix) {
+			text = notePrefix + text
+		}
+		playerState.Journal[i] = text
+		fmt.Printf(Yellow+"Entry %d updated."+Reset+"\n", i+1)
+		return
+	}
+	first := 0
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			fmt.Println("Usage: journal [<n> | add <text> | edit <n> <text> | delete <n> | clear]")
+			return
+		}
+		if n < len(playerState.Journal) {
+			first = len(playerState.Journal) - n
+		}
+	}
+	paged(func() {
+		fmt.Println(Blue + tr("Journal Entries:") + Reset)
+		for i := first; i < len(playerState.Journal); i++ {
+			e := playerState.Journal[i]
+			if strings.HasPrefix(e, notePrefix) {
+				fmt.Printf(Green+" %3d. * %s"+Reset+"\n", i+1, strings.TrimPrefix(e, notePrefix))
+			} else {
+				fmt.Printf(" %3d. - %s\n", i+1, e)
+			}
+		}
+	})
+}
+
+// journalIndex converts a 1-based entry number to a slice index, reporting bad input
+func journalIndex(arg string) (int, bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Println(Red + "Give the entry number shown by 'journal'." + Reset)
+		return 0, false
+	}
+	if n < 1 || n > len(playerState.Journal) {
+		fmt.Printf(Red+"No journal entry %d (you have %d)."+Reset+"\n", n, len(playerState.Journal))
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// giveHint nudges the player, using what they carry, have done and can reach
+func giveHint(topic string) {
+	maybePrune()
+	loc := playerState.CurrentLocation
+	var b strings.Builder
+	if topic != "" {
+		fmt.Fprintf(&b, "I'm at %s and want a hint about %s.\n", loc, topic)
+	} else {
+		fmt.Fprintf(&b, "I'm stuck at %s. Please give me a hint.\n", loc)
+	}
+	if len(playerState.Inventory) > 0 {
+		fmt.Fprintf(&b, "I'm carrying: %s.\n", playerState.Inventory)
+	}
+	if n := len(playerState.Journal); n > 0 {
+		recent := playerState.Journal
+		if n > 5 {
+			recent = recent[n-5:]
+		}
+		fmt.Fprintf(&b, "Recent journal entries: %s\n", strings.Join(recent, " | "))
+	}
+	var exits []string
+	for n := range playerState.MapGraph[loc] {
+		exits = append(exits, n)
+	}
+	if len(exits) > 0 {
+		sort.Strings(exits)
+		fmt.Fprintf(&b, "Known routes from here lead to: %s.\n", strings.Join(exits, ", "))
+	}
+	if goals := activeGoals(); len(goals) > 0 {
+		fmt.Fprintf(&b, "I'm trying to: %s.\n", strings.Join(goals, "; "))
+	}
+	b.WriteString("Reply in one or two sentences. Nudge me toward something worth trying; don't solve it for me or spoil surprises.")
+	hint := normalizeText(callOpenAI(append(history, Message{Role: "user", Content: b.String()})))
+	fmt.Printf(Yellow+"Hint:"+Reset+" %s\n", hint)
+}
+
+// activeGoals returns the text of goals not yet done
+func activeGoals() []string {
+	var out []string
+	for _, g := range playerState.Goals {
+		if !g.Done {
+			out = append(out, g.Text)
+		}
+	}
+	return out
+}
+
+// goalCmd lists, adds or completes player goals
+func goalCmd(arg string) {
+	low := strings.ToLower(arg)
+	switch {
+	case low == "" || low == "list":
+		if len(playerState.Goals) == 0 {
+			fmt.Println("No goals yet. Add one with 'goal add <text>'.")
+			return
+		}
+		fmt.Println(Blue + "Goals:" + Reset)
+		for i, g := range playerState.Goals {
+			if g.Done {
+				fmt.Printf(Green+" %3d. [x] %s"+Reset+"\n", i+1, g.Text)
+			} else {
+				fmt.Printf(Yellow+" %3d. [ ] %s"+Reset+"\n", i+1, g.Text)
+			}
+		}
+	case strings.HasPrefix(low, "add "):
+		text := strings.TrimSpace(arg[4:])
+		playerState.Goals = append(playerState.Goals, Goal{Text: text})
+		fmt.Printf(Yellow+"Goal %d added: %s"+Reset+"\n", len(playerState.Goals), text)
+	case strings.HasPrefix(low, "done "):
+		n, err := strconv.Atoi(strings.TrimSpace(arg[5:]))
+		if err != nil || n < 1 || n > len(playerState.Goals) {
+			fmt.Printf(Red+"No goal '%s' (you have %d)."+Reset+"\n", strings.TrimSpace(arg[5:]), len(playerState.Goals))
+			return
+		}
+		playerState.Goals[n-1].Done = true
+		fmt.Printf(Green+"Goal complete: %s"+Reset+"\n", playerState.Goals[n-1].Text)
+	default:
+		fmt.Println("Usage: goal add <text> | goal done <n> | goal list")
+	}
+}
+
+// uiLang picks the language of the interface; narration follows the model
+var uiLang = "en"
+
+// translations maps English interface text to other languages; anything
+// missing falls back to the English
+var translations = map[string]map[string]string{
+	"es": {
+		"Move to a place or direction":                                    "Ir a un lugar o en una dirección",
+		"Move in a cardinal direction":                                    "Moverse hacia un punto cardinal",
+		"Go back the way you came":                                        "Volver por donde viniste",
+		"Travel to a bookmarked place":                                    "Viajar a un lugar marcado",
+		"Bookmark this place under a label":                               "Marcar este lugar con una etiqueta",
+		"List bookmarked places":                                          "Ver los lugares marcados",
+		"Describe your surroundings":                                      "Describir lo que te rodea",
+		"Inspect something or someone":                                    "Examinar algo o a alguien",
+		"Peek in a direction without moving":                              "Mirar en una dirección sin moverte",
+		"Let time pass and see what happens":                              "Dejar pasar el tiempo y ver qué ocurre",
+		"Rest a few hours to recover stamina":                             "Descansar unas horas para recuperar aguante",
+		"Search for hidden items or passages":                             "Buscar objetos o pasadizos ocultos",
+		"Pick up an item in the scene":                                    "Recoger un objeto de la escena",
+		"Use up one of an item you carry":                                 "Gastar uno de los objetos que llevas",
+		"Leave an item here to lighten your load":                         "Dejar un objeto aquí para aligerar la carga",
+		"Give an item; repeated gifts of a kind count for less":           "Dar un objeto; los regalos repetidos cuentan menos",
+		"Perform a freeform action":                                       "Realizar una acción libre",
+		"List NPCs here":                                                  "Ver quién hay aquí",
+		"Start conversation with someone":                                 "Hablar con alguien",
+		"Start a group conversation":                                      "Iniciar una conversación en grupo",
+		"Ask someone about something straight away":                       "Preguntar a alguien por algo directamente",
+		"List everyone you've met, or drop those not seen lately":         "Ver a quienes conoces, u olvidar a los que no ves hace tiempo",
+		"Remove someone from the people you've met":                       "Olvidar a alguien que conociste",
+		"Rewrite someone's bio from recent events":                        "Reescribir la biografía de alguien según lo reciente",
+		"Show or set how someone speaks":                                  "Ver o fijar cómo habla alguien",
+		"See how your character looks":                                    "Ver el aspecto de tu personaje",
+		"Change your character's name":                                    "Cambiar el nombre de tu personaje",
+		"Rename a place everywhere it appears":                            "Renombrar un lugar allí donde aparezca",
+		"Show your items, optionally one category; -v describes them all": "Ver tus objetos, o una categoría; -v los describe todos",
+		"Show how much gold you carry":                                    "Ver cuánto oro llevas",
+		"Buy an item from a merchant here":                                "Comprar un objeto a un mercader de aquí",
+		"Sell an item to a merchant here":                                 "Vender un objeto a un mercader de aquí",
+		"Show how factions and towns regard you":                          "Ver cómo te consideran facciones y pueblos",
+		"Show your character class":                                       "Ver la clase de tu personaje",
+		"Show an overview of your character":                              "Ver un resumen de tu personaje",
+		"Show your character stats":                                       "Ver los atributos de tu personaje",
+		"Show the day and time of day":                                    "Ver el día y la hora",
+		"Show the current weather":                                        "Ver el tiempo que hace",
+		"Close this chapter and archive its history":                      "Cerrar este capítulo y archivar su historia",
+		"List finished chapters":                                          "Ver los capítulos terminados",
+		"List what you've examined, or recall one":                        "Ver lo que has examinado, o recordar algo",
+		"Summarize the story so far":                                      "Resumir la historia hasta ahora",
+		"Show your journal (or the last n entries)":                       "Ver tu diario (o las últimas n entradas)",
+		"Write your own journal note (* marks notes)":                     "Escribir una nota propia en el diario (* marca las notas)",
+		"Rewrite journal entry n":                                         "Reescribir la entrada n del diario",
+		"Remove journal entry n":                                          "Borrar la entrada n del diario",
+		"Erase the journal after confirming":                              "Borrar el diario tras confirmar",
+		"Track your own goals":                                            "Llevar tus propios objetivos",
+		"Show active and completed goals":                                 "Ver objetivos activos y cumplidos",
+		"Save your current game":                                          "Guardar la partida",
+		"Load a saved game":                                               "Cargar una partida guardada",
+		"List recent save snapshots or roll back to one":                  "Ver las copias recientes o volver a una",
+		"Show or choose the commands that autosave, e.g. move, talk":      "Ver o elegir las órdenes que guardan solas, p. ej. move, talk",
+		"Show the path you have walked":                                   "Ver el camino recorrido",
+		"Show ASCII map (default=current loc)":                            "Ver el mapa ASCII (por defecto, el lugar actual)",
+		"Write the map as a Graphviz DOT graph":                           "Exportar el mapa como grafo DOT de Graphviz",
+		"Show the shortest known route between places":                    "Ver la ruta conocida más corta entre lugares",
+		"Get an in-game hint, optionally about something":                 "Pedir una pista, quizá sobre algo concreto",
+		"List aliases or add one to .advrc":                               "Ver los alias o añadir uno a .advrc",
+		"Add an alias for this save only":                                 "Añadir un alias solo para esta partida",
+		"Regenerate the description of this place":                        "Volver a describir este lugar",
+		"Reuse descriptions when revisiting places":                       "Reutilizar descripciones al volver a un lugar",
+		"Scale check DCs and damage taken":                                "Ajustar la dificultad de las tiradas y el daño",
+		"Show a line of atmosphere on entering places":                    "Mostrar una línea de ambiente al llegar a un lugar",
+		"Show prompts sent to the model":                                  "Mostrar lo que se envía al modelo",
+		"Inspect or edit the raw history (debug only)":                    "Ver o editar el historial en bruto (solo depuración)",
+		"Enable/disable history summarization":                            "Activar o desactivar el resumen del historial",
+		"Show or change how history is summarized":                        "Ver o cambiar cómo se resume el historial",
+		"Cast a known spell (uses a spell slot)":                          "Lanzar un hechizo conocido (gasta un espacio)",
+		"List known spells and spell slots":                               "Ver hechizos conocidos y espacios de conjuro",
+		"Perform a d20 skill/attribute check":                             "Hacer una tirada de d20 de atributo",
+		"List named checks or roll one, e.g. check stealth":               "Ver las pruebas con nombre o tirar una, p. ej. check stealth",
+		"Define a named check":                                            "Definir una prueba con nombre",
+		"Re-roll the last narration":                                      "Repetir la última narración",
+		"Hear more of the last description":                               "Oír más de la última descripción",
+		"Re-run your last command":                                        "Repetir tu última orden",
+		"Show how a command would be understood, without running it":      "Ver cómo se entendería una orden, sin ejecutarla",
+		"Show this help text":                                             "Mostrar esta ayuda",
+		"End the adventure or exit NPC chat":                              "Terminar la aventura o salir de una conversación",
+		"Available commands:":                                             "Órdenes disponibles:",
+		"Welcome to the Immersive Text Adventure!":                        "¡Bienvenido a la Aventura de Texto Inmersiva!",
+		"1) New game  2) Load game  3) Quit":                              "1) Nueva partida  2) Cargar partida  3) Salir",
+		"No save file found.":                                             "No se encontró ninguna partida guardada.",
+		"Goodbye!":                                                        "¡Adiós!",
+		"Inventory:":                                                      "Inventario:",
+		"Empty":                                                           "Vacío",
+		"Load:":                                                           "Carga:",
+		"Gold:":                                                           "Oro:",
+		"(over-encumbered: -2 to STR and DEX checks)":                     "(sobrecargado: -2 a las tiradas de STR y DEX)",
+		"Journal Entries:":                                                "Entradas del diario:",
+		"HP":                                                              "PV",
+		"Stats":                                                           "Atributos",
+		"Gold":                                                            "Oro",
+		"Load":                                                            "Carga",
+		"Stamina":                                                         "Aguante",
+		"Slots":                                                           "Espacios",
+		"Effects":                                                         "Efectos",
+		"Location":                                                        "Lugar",
+		"Journal":                                                         "Diario",
+		"NPCs":                                                            "PNJ",
+		"%d entries":                                                      "%d entradas",
+		"%d known":                                                        "%d conocidos",
+		"Unnamed traveler":                                                "Viajero sin nombre",
+		"(over-encumbered)":                                               "(sobrecargado)",
+		"(y/n)":                                                           "(s/n)",
+		"y":                                                               "s",
+		"yes":                                                             "sí",
+	},
+}
+
+// languageNames names each interface language for the narrator
+var languageNames = map[string]string{"en": "English", "es": "Spanish"}
+
+// tr translates a fixed interface string into the chosen language
+func tr(en string) string {
+	if t, ok := translations[uiLang][en]; ok {
+		return t
+	}
+	return en
+}
+
+// helpLine prints one aligned, translated line of the help text
+func helpLine(usage, desc string) {
+	fmt.Printf("  %-36s - %s\n", usage, tr(desc))
+}
+
+// printHelp displays the list of available commands
+func printHelp() {
+	fmt.Println()
+	fmt.Println(tr("Available commands:"))
+	helpLine("go to/move to/travel to <location>", "Move to a place or direction")
+	helpLine("north/south/east/west", "Move in a cardinal direction")
+	helpLine("back / return", "Go back the way you came")
+	helpLine("go to @<label>", "Travel to a bookmarked place")
+	helpLine("bookmark <label>", "Bookmark this place under a label")
+	helpLine("bookmarks", "List bookmarked places")
+	helpLine("look / observe / where", "Describe your surroundings")
+	helpLine("examine <object> / look at <object> / inspect <object>", "Inspect something or someone")
+	helpLine("look <direction>", "Peek in a direction without moving")
+	helpLine("wait", "Let time pass and see what happens")
+	helpLine("rest", "Rest a few hours to recover stamina")
+	helpLine("search [<area>]", "Search for hidden items or passages")
+	helpLine("take <item>", "Pick up an item in the scene")
+	helpLine("use <item>", "Use up one of an item you carry")
+	helpLine("drop <item>", "Leave an item here to lighten your load")
+	helpLine("give <item> to <person>", "Give an item; repeated gifts of a kind count for less")
+	helpLine("do <action> / emote <action>", "Perform a freeform action")
+	helpLine("talk to", "List NPCs here")
+	helpLine("talk to <NPC name or number>", "Start conversation with someone")
+	helpLine("talk to all / talk to <X> and <Y>", "Start a group conversation")
+	helpLine("ask <NPC> about <topic>", "Ask someone about something straight away")
+	helpLine("npcs [prune [<days>]]", "List everyone you've met, or drop those not seen lately")
+	helpLine("forget <NPC name>", "Remove someone from the people you've met")
+	helpLine("npc regen <NPC name>", "Rewrite someone's bio from recent events")
+	helpLine("npc voice <NPC name> [<style>]", "Show or set how someone speaks")
+	helpLine("describe me / appearance", "See how your character looks")
+	helpLine("rename <name>", "Change your character's name")
+	helpLine("rename location <old> to <new>", "Rename a place everywhere it appears")
+	helpLine("inventory [-v] [<category>]", "Show your items, optionally one category; -v describes them all")
+	helpLine("gold / wallet", "Show how much gold you carry")
+	helpLine("buy <item>", "Buy an item from a merchant here")
+	helpLine("sell <item>", "Sell an item to a merchant here")
+	helpLine("reputation", "Show how factions and towns regard you")
+	helpLine("class", "Show your character class")
+	helpLine("status", "Show an overview of your character")
+	helpLine("stats", "Show your character stats")
+	helpLine("time", "Show the day and time of day")
+	helpLine("weather", "Show the current weather")
+	helpLine("chapter end", "Close this chapter and archive its history")
+	helpLine("chapters", "List finished chapters")
+	helpLine("known / lore [<name>]", "List what you've examined, or recall one")
+	helpLine("recap", "Summarize the story so far")
+	helpLine("journal [<n>]", "Show your journal (or the last n entries)")
+	helpLine("note <text> / journal add <text>", "Write your own journal note (* marks notes)")
+	helpLine("journal edit <n> <text>", "Rewrite journal entry n")
+	helpLine("journal delete <n>", "Remove journal entry n")
+	helpLine("journal clear", "Erase the journal after confirming")
+	helpLine("goal add <text> / goal done <n>", "Track your own goals")
+	helpLine("goals / goal list", "Show active and completed goals")
+	helpLine("save", "Save your current game")
+	helpLine("load", "Load a saved game")
+	helpLine("saves [restore <n>]", "List recent save snapshots or roll back to one")
+	helpLine("set autosave-on [<commands>|none]", "Show or choose the commands that autosave, e.g. move, talk")
+	helpLine("trail", "Show the path you have walked")
+	helpLine("map [<location>]", "Show ASCII map (default=current loc)")
+	helpLine("map export <file.dot>", "Write the map as a Graphviz DOT graph")
+	helpLine("distance [<place> to] <place>", "Show the shortest known route between places")
+	helpLine("hint [<topic>]", "Get an in-game hint, optionally about something")
+	helpLine("set alias [<short> <command>]", "List aliases or add one to .advrc")
+	helpLine("set alias -s <short> <command>", "Add an alias for this save only")
+	helpLine("rescan", "Regenerate the description of this place")
+	helpLine("set persistent-scenes on|off", "Reuse descriptions when revisiting places")
+	helpLine("set difficulty easy|normal|hard", "Scale check DCs and damage taken")
+	helpLine("set ambient on|off", "Show a line of atmosphere on entering places")
+	helpLine("set debug on|off", "Show prompts sent to the model")
+	helpLine("history [show|delete <n>]", "Inspect or edit the raw history (debug only)")
+	helpLine("set prune on|off", "Enable/disable history summarization")
+	helpLine("set summary-prompt [<text>]", "Show or change how history is summarized")
+	helpLine("cast <spell>", "Cast a known spell (uses a spell slot)")
+	helpLine("spells", "List known spells and spell slots")
+	helpLine("roll <STAT> [DC]", "Perform a d20 skill/attribute check")
+	helpLine("check [<name> [DC]]", "List named checks or roll one, e.g. check stealth")
+	helpLine("set check <name> <STAT> [DC]", "Define a named check")
+	helpLine("regenerate / redo", "Re-roll the last narration")
+	helpLine("more", "Hear more of the last description")
+	helpLine("repeat / g", "Re-run your last command")
+	helpLine("parse <command>", "Show how a command would be understood, without running it")
+	helpLine("help / ?", "Show this help text")
+	helpLine("quit / exit / stop", "End the adventure or exit NPC chat")
+	fmt.Println()
+}
+
+func main() {
+	flag.IntVar(&pruneTokens, "prune-tokens", pruneTokens, "approximate history tokens that trigger summarization (0 = derive from model)")
+	flag.IntVar(&pruneTailTokens, "prune-tail-tokens", pruneTailTokens, "approximate tokens of recent history never summarized")
+	flag.BoolVar(&debugMode, "debug", false, "print each prompt sent to the model on stderr")
+	flag.StringVar(&globalModel, "model", "gpt-4.1-mini", "OpenAI chat model to use")
+	flag.StringVar(&dataDir, "data-dir", "", "directory for saves, logs and .advrc (default: the OS data directory)")
+	flag.StringVar(&logPath, "log", "", "append a JSON-lines transcript of the session to this file (relative to the data dir)")
+	flag.StringVar(&replayPath, "replay", "", "re-issue the commands from a transcript non-interactively")
+	flag.StringVar(&uiLang, "lang", uiLang, "interface language: en or es")
+	flag.IntVar(&travelCost, "travel-cost", travelCost, "stamina spent per move (0 turns stamina off)")
+	flag.IntVar(&encounterChance, "encounter-chance", encounterChance, "base percent chance of a random encounter on entering a place (0 disables)")
+	flag.StringVar(&statRoll, "stat-roll", statRoll, "how new characters roll attributes: range (8-18), 3d6 or 4d6 (drop lowest)")
+	flag.IntVar(&saveRing, "save-ring", saveRing, "how many recent saves to keep as snapshots for 'saves restore' (0 keeps none)")
+	flag.IntVar(&conversationTokens, "conversation-tokens", conversationTokens, "approximate tokens after which a conversation's older turns are summarized (0 never)")
+	flag.BoolVar(&noColor, "no-color", false, "print without ANSI colors (also turns off paging)")
+	flag.BoolVar(&usePager, "pager", false, "show long listings through $PAGER instead of the built-in -- more -- prompt")
+	flag.StringVar(&startScene, "start", "", "begin new games here instead of asking (empty uses the default scene)")
+	flag.BoolVar(&quickStart, "quick-start", false, "begin new games at the start scene last chosen, saved in .advrc")
+	flag.StringVar(&scriptPath, "script", "", "read commands from this file, one per line, as if typed (# starts a comment)")
+	flag.BoolVar(&thenInteractive, "then-interactive", false, "after -script runs out, keep playing from the terminal instead of exiting")
+	flag.BoolVar(&replayStopOnDiff, "replay-stop-on-diff", false, "halt a replay when a command classifies differently than recorded")
+	flag.StringVar(&startDifficulty, "difficulty", startDifficulty, "difficulty for new games: easy, normal or hard")
+	flag.BoolVar(&toolsEnabled, "tools", false, "let the model change game state through function calls instead of text markers")
+	flag.BoolVar(&askStays, "ask-stays", false, "stay in the conversation after 'ask <name> about <topic>' is answered")
+	flag.BoolVar(&showDiff, "show-diff", false, "show the replaced narration alongside the new one on regenerate")
+	flag.StringVar(&themeName, "theme", themeName, "built-in world theme for new games: fantasy, cyberpunk or horror")
+	flag.StringVar(&systemPromptFile, "system-prompt-file", "", "load the narrator's system prompt for new games from this file")
+	flag.StringVar(&bannerFile, "banner-file", "", "show the ASCII art in this file as the title banner instead of the theme's")
+	flag.StringVar(&summaryPromptFile, "summary-prompt-file", "", "load the prompt used to summarize old history from this file")
+	flag.IntVar(&maxRetries, "retries", maxRetries, "times to retry a failed API request before giving up")
+	flag.DurationVar(&requestTimeout, "timeout", 0, "time allowed for every API request, e.g. 45s (default 15s for lists and summaries, 60s for narration)")
+	flag.Int64Var(&seedFlag, "seed", 0, "seed the dice for a reproducible session (0 picks one from the clock)")
+	flag.Parse()
+	if noColor {
+		disableColor()
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "start" {
+			startSet = true
+		}
+	})
+	maxRetries = max(0, maxRetries)
+	if requestTimeout > 0 {
+		quickTimeout, narrationTimeout = requestTimeout, requestTimeout
+	}
+	if seedFlag != 0 {
+		seedRNG(seedFlag, 0)
+	}
+	fmt.Printf(Dim+"Seed: %d"+Reset+"\n", rngSeed)
+	if statRoll != "range" && statRoll != "3d6" && statRoll != "4d6" {
+		fmt.Fprintln(os.Stderr, Red+"Unknown stat roll "+statRoll+" (choose range, 3d6 or 4d6)"+Reset)
+		os.Exit(1)
+	}
+	if _, ok := languageNames[uiLang]; !ok {
+		fmt.Fprintln(os.Stderr, Yellow+"Unknown language "+uiLang+"; using English."+Reset)
+		uiLang = "en"
+	}
+	if _, ok := difficultyDC[startDifficulty]; !ok {
+		fmt.Fprintln(os.Stderr, Red+"Unknown difficulty "+startDifficulty+" (choose easy, normal or hard)"+Reset)
+		os.Exit(1)
+	}
+	if err := resolveSystemPrompt(); err != nil {
+		fmt.Fprintln(os.Stderr, Red+"System prompt error: "+err.Error()+Reset)
+		os.Exit(1)
+	}
+	if summaryPromptFile != "" {
+		b, err := ioutil.ReadFile(summaryPromptFile)
+		if err != nil || strings.TrimSpace(string(b)) == "" {
+			fmt.Fprintln(os.Stderr, Red+"Summary prompt file error: "+summaryPromptFile+" is missing or empty"+Reset)
+			os.Exit(1)
+		}
+		summaryPrompt = strings.TrimSpace(string(b))
+	}
+	globalAPIKey = os.Getenv("OPENAI_API_KEY")
+	globalOrg, globalProject = os.Getenv("OPENAI_ORG"), os.Getenv("OPENAI_PROJECT")
+	if globalAPIKey == "" {
+		fmt.Fprintln(os.Stderr, Red+"OPENAI_API_KEY not set"+Reset)
+		os.Exit(1)
+	}
+	setupDataDir()
+	loadRC()
+	applyMessageSettings()
+	if logPath != "" {
+		f, err := os.OpenFile(dataPath(logPath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Transcript error:", err)
+		} else {
+			transcriptFile = f
+			defer f.Close()
+		}
+	}
+	if scriptPath != "" {
+		if err := loadScript(scriptPath); err != nil {
+			fmt.Fprintln(os.Stderr, Red+"Script error: "+err.Error()+Reset)
+			os.Exit(1)
+		}
+	}
+	if replayPath != "" {
+		if err := replay(replayPath, replayStopOnDiff); err != nil {
+			fmt.Fprintln(os.Stderr, Red+"Replay stopped: "+err.Error()+Reset)
+			os.Exit(1)
+		}
+		return
+	}
+
+	handleShutdown()
+	// main owns game state from here on, releasing it only to wait for input
+	lockState()
+	defer unlockState()
+
+	// Main menu
+	printBanner()
+	var loaded []Message
+	if _, err := os.Stat(dataPath(crashFile)); err == nil {
+		fmt.Println(Yellow + "An emergency save from an interrupted session was found." + Reset)
+		if confirm("Recover it?") {
+			if h, err := readSave(dataPath(crashFile)); err == nil && len(h) > 0 {
+				loaded = h
+				history = h
+				os.Remove(dataPath(crashFile))
+				if history[len(history)-1].Role == "assistant" {
+					fmt.Println(Blue + history[len(history)-1].Content + Reset)
+				}
+			} else {
+				fmt.Println(Red + "The emergency save could not be read." + Reset)
+			}
+		}
+	}
+	choice := "1"
+	if len(loaded) == 0 {
+		fmt.Print(tr("1) New game  2) Load game  3) Quit") + "\n> ")
+		choice, _ = readLine()
+	}
+	if choice == "2" {
+		h, err := loadGame()
+		if err != nil {
+			fmt.Print(Red + tr("No save file found.") + Reset + "\n")
+			initPlayerState()
+		} else {
+			loaded = h
+			history = h
+			if len(history) > 0 && history[len(history)-1].Role == "assistant" {
+				fmt.Println(Blue + history[len(history)-1].Content + Reset)
+			}
+		}
+	} else if choice == "3" {
+		fmt.Println(Yellow + tr("Goodbye!") + Reset)
+		return
+	}
+	if len(loaded) == 0 {
+		initPlayerState()
+		start := chooseStart()
+		createCharacter()
+		fmt.Println()
+		beginAdventure(start)
+		printHelp()
+	}
+
+	// Game loop
+	for {
+		loc := playerState.CurrentLocation
+		if loc != "" {
+			fmt.Printf("%s [Day %d, %s, %dg]> ", loc, playerState.Day, timeOfDay(playerState.Hour), playerState.Gold)
+		} else {
+			fmt.Print("> ")
+		}
+		cmd, err := readLine()
+		if err != nil && cmd == "" {
+			fmt.Println()
+			return
+		}
+		_, err = dispatch(cmd)
+		if err == errQuit {
+			os.Remove(dataPath(crashFile))
+			return
+		}
+		if errors.Is(lastCallErr, ErrAuth) {
+			fmt.Fprintln(os.Stderr, Red+"The API rejected OPENAI_API_KEY. Check the key and restart; your game is kept as an emergency save."+Reset)
+			if err := writeSave(dataPath(crashFile), history); err != nil {
+				fmt.Fprintln(os.Stderr, "Emergency save failed:", err)
+			}
+			os.Exit(1)
+		}
+	}
+}
+
+// Verb identifies what a command does
+type Verb int
+
+const (
+	VerbNarrate Verb = iota // freeform input forwarded to the narrator
+	VerbMove
+	VerbLook
+	VerbExamine
+	VerbTalk
+	VerbListNpcs
+	VerbRoll
+	VerbMap
+	VerbSearch
+	VerbTake
+	VerbWait
+	VerbInventory
+	VerbStats
+	VerbJournal
+	VerbSave
+	VerbLoad
+	VerbTime
+	VerbWeather
+	VerbHint
+	VerbHelp
+	VerbQuit
+	VerbRepeat
+	VerbSetAlias
+	VerbSetPrune
+	VerbAppearance
+	VerbRename
+	VerbNote
+	VerbGoal
+	VerbDo
+	VerbRescan
+	VerbSetPersistentScenes
+	VerbSetDebug
+	VerbClass
+	VerbReputation
+	VerbGold
+	VerbBuy
+	VerbSell
+	VerbDrop
+	VerbUse
+	VerbPeek
+	VerbTrail
+	VerbBack
+	VerbCast
+	VerbSpells
+	VerbStatus
+	VerbRecap
+	VerbChapterEnd
+	VerbChapters
+	VerbMore
+	VerbSetAmbient
+	VerbRegenerate
+	VerbLore
+	VerbSetDifficulty
+	VerbMapExport
+	VerbRenameLocation
+	VerbGive
+	VerbForget
+	VerbNpcs
+	VerbCheck
+	VerbSetCheck
+	VerbSaves
+	VerbHistory
+	VerbRest
+	VerbSetSummaryPrompt
+	VerbBookmark
+	VerbBookmarks
+	VerbAsk
+	VerbDistance
+	VerbSetAutosave
+	VerbNpc
+	VerbParse
+)
+
+var verbNames = [...]string{"narrate", "move", "look", "examine", "talk", "list-npcs", "roll", "map",
+	"search", "take", "wait", "inventory", "stats", "journal", "save", "load", "time", "weather",
+	"hint", "help", "quit", "repeat", "set-alias", "set-prune", "appearance", "rename", "note", "goal", "do", "rescan", "set-persistent-scenes", "set-debug", "class", "reputation", "gold", "buy", "sell", "drop", "use", "peek", "trail", "back", "cast", "spells", "status", "recap", "chapter-end", "chapters", "more", "set-ambient", "regenerate", "lore", "set-difficulty", "map-export", "rename-location", "give", "forget", "npcs", "check", "set-check", "saves", "history", "rest", "set-summary-prompt", "bookmark", "bookmarks", "ask", "distance", "set-autosave", "npc", "parse"}
+
+func (v Verb) String() string {
+	if int(v) < len(verbNames) {
+		return verbNames[v]
+	}
+	return "unknown"
+}
+
+// isMeta reports whether commands of this verb must never be repeated
+func (v Verb) isMeta() bool {
+	switch v {
+	case VerbSave, VerbLoad, VerbQuit, VerbRepeat, VerbSetAlias, VerbSetPrune, VerbSetPersistentScenes, VerbSetDebug,
+		VerbChapterEnd, VerbSetAmbient, VerbRegenerate, VerbSetDifficulty, VerbSaves, VerbHistory, VerbSetSummaryPrompt, VerbBookmark, VerbBookmarks, VerbDistance, VerbSetAutosave, VerbParse:
+		return true
+	}
+	return false
+}
+
+// Command is a parsed line of player input
+type Command struct {
+	Verb Verb
+	Arg  string // target, destination, stat or setting value
+	N    int    // numeric argument such as a DC; 0 when absent
+	Raw  string // the input as typed, after alias expansion
+}
+
+// Fixed single-word (or phrase) commands
+var simpleVerbs = map[string]Verb{
+	"repeat": VerbRepeat, "g": VerbRepeat,
+	"quit": VerbQuit, "exit": VerbQuit, "stop": VerbQuit,
+	"help": VerbHelp, "?": VerbHelp,
+	"inventory": VerbInventory, "stats": VerbStats,
+	"save": VerbSave, "load": VerbLoad, "time": VerbTime, "weather": VerbWeather,
+	"wait": VerbWait, "rest": VerbRest,
+	"look": VerbLook, "observe": VerbLook, "where": VerbLook, "talk to": VerbListNpcs, "goals": VerbGoal,
+	"rescan": VerbRescan, "class": VerbClass, "reputation": VerbReputation, "rep": VerbReputation,
+	"gold": VerbGold, "wallet": VerbGold, "trail": VerbTrail,
+	"back": VerbBack, "return": VerbBack, "go back": VerbBack, "spells": VerbSpells, "status": VerbStatus, "recap": VerbRecap,
+	"chapter end": VerbChapterEnd, "chapters": VerbChapters, "more": VerbMore,
+	"regenerate": VerbRegenerate, "redo": VerbRegenerate, "known": VerbLore,
+	"describe me": VerbAppearance, "appearance": VerbAppearance,
+	"look at me": VerbAppearance, "look at self": VerbAppearance, "examine me": VerbAppearance, "examine self": VerbAppearance,
+}
+
+// Commands taking the rest of the line as their argument, checked in order
+var prefixVerbs = []struct {
+	prefix string
+	verb   Verb
+}{
+	{"set alias", VerbSetAlias}, {"set prune", VerbSetPrune}, {"set persistent-scenes", VerbSetPersistentScenes}, {"set summary-prompt", VerbSetSummaryPrompt}, {"set autosave-on", VerbSetAutosave},
+	{"set debug", VerbSetDebug}, {"set ambient", VerbSetAmbient}, {"set difficulty", VerbSetDifficulty}, {"set check", VerbSetCheck},
+	{"talk to ", VerbTalk}, {"search", VerbSearch}, {"take ", VerbTake}, {"give ", VerbGive}, {"drop ", VerbDrop}, {"use ", VerbUse}, {"inventory", VerbInventory},
+	{"examine ", VerbExamine}, {"look at ", VerbExamine}, {"inspect ", VerbExamine}, {"look ", VerbPeek},
+	{"go to ", VerbMove}, {"move to ", VerbMove}, {"travel to ", VerbMove},
+	{"roll", VerbRoll}, {"map export", VerbMapExport}, {"map", VerbMap}, {"rename location ", VerbRenameLocation}, {"rename", VerbRename},
+	{"journal", VerbJournal}, {"note ", VerbNote}, {"goal", VerbGoal},
+	{"hint", VerbHint}, {"do ", VerbDo}, {"emote ", VerbDo},
+	{"buy", VerbBuy}, {"sell", VerbSell}, {"cast", VerbCast}, {"lore", VerbLore},
+	{"forget ", VerbForget}, {"ask ", VerbAsk}, {"npcs", VerbNpcs}, {"npc ", VerbNpc}, {"parse ", VerbParse}, {"check", VerbCheck}, {"saves", VerbSaves}, {"history", VerbHistory}, {"bookmarks", VerbBookmarks}, {"bookmark", VerbBookmark}, {"distance", VerbDistance},
+}
+
+// parseCommand classifies a line of input without running it
+func parseCommand(cmd string) Command {
+	cmd = strings.TrimSpace(cmd)
+	lc := strings.ToLower(cmd)
+	c := Command{Verb: VerbNarrate, Raw: cmd}
+	if v, ok := simpleVerbs[lc]; ok {
+		c.Verb = v
+		return c
+	}
+	switch lc {
+	case "north", "south", "east", "west":
+		c.Verb, c.Arg = VerbMove, titleCase(lc)
+		return c
+	}
+	for _, p := range prefixVerbs {
+		if !strings.HasPrefix(lc, p.prefix) {
+			continue
+		}
+		// word prefixes must end at a word boundary ("map", not "maple")
+		if rest := lc[len(p.prefix):]; !strings.HasSuffix(p.prefix, " ") && rest != "" && rest[0] != ' ' {
+			continue
+		}
+		c.Verb = p.verb
+		c.Arg = strings.TrimSpace(cmd[len(p.prefix):])
+		break
+	}
+	switch c.Verb {
+	case VerbMove, VerbMap:
+		// bookmark labels are resolved when the move runs
+		if !strings.HasPrefix(c.Arg, "@") {
+			c.Arg = titleCase(c.Arg)
+		}
+	case VerbSetPrune, VerbSetPersistentScenes, VerbSetDebug, VerbSetAmbient, VerbSetDifficulty:
+		c.Arg = strings.ToLower(c.Arg)
+	case VerbSearch:
+		if c.Arg == "" {
+			c.Arg = "the surroundings"
+		}
+	case VerbExamine, VerbPeek:
+		dir := strings.ToLower(c.Arg)
+		if directions[dir] {
+			c.Verb, c.Arg = VerbPeek, dir
+		} else if c.Verb == VerbPeek {
+			c.Verb, c.Arg = VerbNarrate, ""
+		}
+	case VerbRoll:
+		parts := strings.Fields(c.Arg)
+		c.Arg = ""
+		if len(parts) >= 1 {
+			c.Arg = strings.ToUpper(parts[0])
+		}
+		if len(parts) >= 2 {
+			if dc, err := strconv.Atoi(parts[1]); err == nil {
+				c.N = dc
+			}
+		}
+	}
+	return c
+}
+
+// explainCommand shows how a line would be classified, without running it
+func explainCommand(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		fmt.Println("Usage: parse <command>")
+		return
+	}
+	expanded := expandAlias(line)
+	if expanded != line {
+		fmt.Printf(Dim+"alias: %q expands to %q"+Reset+"\n", line, expanded)
+	}
+	c := parseCommand(expanded)
+	fmt.Printf(Dim+"%+v"+Reset+"\n", c)
+	if c.Arg != "" && !strings.Contains(expanded, c.Arg) {
+		fmt.Printf(Dim+"argument normalized to %q"+Reset+"\n", c.Arg)
+	}
+	switch {
+	case c.Verb == VerbNarrate:
+		fmt.Println("This would be sent to the narrator as freeform.")
+	case c.Verb == VerbMove && strings.HasPrefix(c.Arg, "@"):
+		if dest, ok := playerState.Bookmarks[strings.ToLower(c.Arg[1:])]; ok {
+			fmt.Printf("This would MOVE to bookmark %s, '%s'.\n", c.Arg, dest)
+		} else {
+			fmt.Printf("This would MOVE to bookmark %s, which doesn't exist.\n", c.Arg)
+		}
+	case c.Verb == VerbMove:
+		fmt.Printf("This would MOVE to '%s'.\n", c.Arg)
+	case c.Arg != "":
+		fmt.Printf("This would %s '%s'.\n", strings.ToUpper(c.Verb.String()), c.Arg)
+	default:
+		fmt.Printf("This would %s.\n", strings.ToUpper(c.Verb.String()))
+	}
+}
+
+// errQuit is returned by dispatch when the player ends the adventure
+var errQuit = errors.New("quit")
+
+// dispatch expands aliases, parses and runs one line of player input
+func dispatch(cmd string) (handled bool, err error) {
+	cmd = strings.TrimSpace(cmd)
+	if cmd == "" {
+		return false, nil
+	}
+	raw, histLen := cmd, len(history)
+	defer func() { logCommand(raw, histLen) }()
+	c := parseCommand(expandAlias(cmd))
+	// repeat / g re-runs the last command through the same path
+	if c.Verb == VerbRepeat {
+		if lastCmd == "" {
+			fmt.Println("Nothing to repeat.")
+			return true, nil
+		}
+		fmt.Printf(Yellow+"(repeating: %s)"+Reset+"\n", lastCmd)
+		c = parseCommand(lastCmd)
+	} else if !c.Verb.isMeta() {
+		lastCmd = c.Raw
+	}
+	if c.Verb != VerbRegenerate {
+		regenReady = false
+	}
+	switch c.Verb {
+	case VerbQuit:
+		fmt.Println(Yellow + "Farewell, traveler!" + Reset)
+		return true, errQuit
+	case VerbHelp:
+		printHelp()
+	case VerbSetAlias:
+		setAlias(c.Arg)
+	case VerbSetPrune:
+		setPrune(c.Arg)
+	case VerbSetAutosave:
+		setAutosave(c.Arg)
+	case VerbSetPersistentScenes:
+		if c.Arg != "on" && c.Arg != "off" {
+			fmt.Println("Usage: set persistent-scenes on|off")
+			break
+		}
+		persistentScenes = c.Arg == "on"
+		if persistentScenes {
+			fmt.Println("Revisited locations reuse their first description.")
+		} else {
+			fmt.Println("Revisited locations are described afresh.")
+		}
+	case VerbSetDifficulty:
+		if _, ok := difficultyDC[c.Arg]; !ok {
+			fmt.Printf("Usage: set difficulty easy|normal|hard (now %s)\n", playerState.Difficulty)
+			break
+		}
+		playerState.Difficulty = c.Arg
+		fmt.Printf("Difficulty set to %s: DCs %+d, damage taken %d%%.\n", c.Arg, difficultyDC[c.Arg], difficultyDamage[c.Arg])
+	case VerbSetSummaryPrompt:
+		if c.Arg == "" {
+			fmt.Println("Summary prompt: " + summaryPrompt)
+			break
+		}
+		summaryPrompt = c.Arg
+		fmt.Println("History will be summarized with the new prompt.")
+	case VerbSetAmbient:
+		if c.Arg != "on" && c.Arg != "off" {
+			fmt.Println("Usage: set ambient on|off")
+			break
+		}
+		ambientEnabled = c.Arg == "on"
+		if ambientEnabled {
+			fmt.Println("Ambient lines will be shown on entering a location.")
+		} else {
+			fmt.Println("Ambient lines are hidden.")
+		}
+	case VerbSetDebug:
+		if c.Arg != "on" && c.Arg != "off" {
+			fmt.Println("Usage: set debug on|off")
+			break
+		}
+		debugMode = c.Arg == "on"
+		state := "disabled"
+		if debugMode {
+			state = "enabled"
+		}
+		fmt.Printf("Debug output %s.\n", state)
+	case VerbClass:
+		fmt.Printf(Yellow+"Class:"+Reset+" %s\n", playerState.Class)
+		for _, cc := range classes {
+			if cc.Name == playerState.Class && len(cc.Priority) > 0 {
+				fmt.Printf(" Key attributes: %s, %s\n", cc.Priority[0], cc.Priority[1])
+			}
+		}
+	case VerbReputation:
+		showReputation()
+	case VerbDrop:
+		dropItem(c.Raw, c.Arg)
+	case VerbLore:
+		if c.Arg == "" {
+			paged(func() { showLore("") })
+		} else {
+			// naming one may ask which is meant, so it can't be captured
+			showLore(c.Arg)
+		}
+	case VerbRegenerate:
+		regenerate()
+	case VerbMore:
+		if len(history) == 0 || history[len(history)-1].Role != "assistant" {
+			fmt.Println("There's nothing to continue.")
+			break
+		}
+		narrateTurn("Continue the last description with a little more detail, without repeating what was already said.")
+	case VerbChapterEnd:
+		endChapter()
+	case VerbChapters:
+		listChapters()
+	case VerbRecap:
+		recap()
+	case VerbStatus:
+		showStatus()
+	case VerbCast:
+		castSpell(c.Arg)
+	case VerbSpells:
+		showSpells()
+	case VerbBack:
+		goBack()
+	case VerbTrail:
+		showTrail()
+	case VerbPeek:
+		peek(c)
+	case VerbUse:
+		useItem(c.Arg)
+	case VerbGold:
+		fmt.Printf(Yellow+"Gold:"+Reset+" %d\n", playerState.Gold)
+	case VerbBuy:
+		buyItem(c.Arg)
+	case VerbSell:
+		sellItem(c.Arg)
+	case VerbRescan:
+		loc := playerState.CurrentLocation
+		resp := narrateTurn(fmt.Sprintf("I take a fresh look around %s. Describe it anew, noting anything that has changed.", loc))
+		if !failedNarration(resp) {
+			sceneDescriptions[loc] = resp
+		}
+		printEnvironmentSummary(history)
+	case VerbInventory:
+		showInventory(c.Arg)
+	case VerbStats:
+		fmt.Printf(" HP: %d/%d\n", playerState.HP, playerState.MaxHP)
+		if len(playerState.Effects) > 0 {
+			fmt.Printf(" Effects: %s\n", effectsSummary())
+		}
+		for _, k := range playerState.StatNames {
+			fmt.Printf(" %s: %d\n", k, playerState.Stats[k])
+		}
+	case VerbJournal:
+		journalCmd(c.Arg)
+	case VerbNote:
+		addNote(c.Arg)
+	case VerbGoal:
+		goalCmd(c.Arg)
+	case VerbSave:
+		saveGame(history)
+	case VerbLoad:
+		if h, err := loadGame(); err == nil {
+			history = h
+		}
+	case VerbSaves:
+		savesCmd(c.Arg)
+	case VerbHistory:
+		historyCmd(c.Arg)
+	case VerbTime:
+		fmt.Printf(Yellow+"Day %d, %s (%02d:00)"+Reset+"\n", playerState.Day, timeOfDay(playerState.Hour), playerState.Hour)
+	case VerbWeather:
+		note := ""
+		if isIndoors(playerState.CurrentLocation) {
+			note = " (muffled, you are indoors)"
+		}
+		fmt.Printf(Yellow+"Weather:"+Reset+" %s%s\n", playerState.Weather, note)
+	case VerbHint:
+		giveHint(c.Arg)
+	case VerbRoll:
+		rollCheck(c)
+	case VerbCheck:
+		runCheck(c.Arg)
+	case VerbSetCheck:
+		setCheck(c.Arg)
+	case VerbMap:
+		if strings.HasPrefix(c.Arg, "@") {
+			dest, ok := bookmarkDest(c.Arg[1:])
+			if !ok {
+				break
+			}
+			c.Arg = dest
+		}
+		paged(func() { showMap(c.Arg) })
+	case VerbMapExport:
+		if c.Arg == "" {
+			fmt.Println("Usage: map export <file.dot>")
+			break
+		}
+		if err := exportMap(c.Arg); err != nil {
+			fmt.Fprintln(os.Stderr, "Map export error:", err)
+		} else {
+			fmt.Println(Green + "Map written to " + c.Arg + "." + Reset)
+		}
+	case VerbListNpcs:
+		npcs := lastNpcs
+		if lastNpcsLoc != playerState.CurrentLocation || npcs == nil {
+			npcs = listNpcs(history)
+		}
+		if len(npcs) == 0 {
+			fmt.Println(Yellow + "There's no one here to talk to." + Reset)
+		} else {
+			numbered := make([]string, len(npcs))
+			for i, n := range npcs {
+				numbered[i] = fmt.Sprintf("%d) %s", i+1, n)
+			}
+			fmt.Printf(Green+"You can talk to:"+Reset+" %s\n", strings.Join(numbered, "  "))
+		}
+	case VerbTalk:
+		if c.Arg == "" {
+			fmt.Println("Usage: talk to <full NPC name>")
+			break
+		}
+		var names []string
+		if n, err := strconv.Atoi(c.Arg); err == nil {
+			// a number picks from the list 'talk to' showed for this scene
+			if lastNpcsLoc != playerState.CurrentLocation || len(lastNpcs) == 0 {
+				fmt.Println("Type 'talk to' first to see who's here.")
+				break
+			}
+			if n < 1 || n > len(lastNpcs) {
+				fmt.Printf(Red+"Pick a number from 1 to %d."+Reset+"\n", len(lastNpcs))
+				break
+			}
+			names = []string{lastNpcs[n-1]}
+		} else if strings.EqualFold(c.Arg, "all") || strings.EqualFold(c.Arg, "everyone") {
+			names = listNpcs(history)
+		} else {
+			for _, part := range splitNames(c.Arg) {
+				if name := resolveNpcName(part); name != "" && !contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+		switch len(names) {
+		case 0:
+			fmt.Println(Yellow + "There's no one here to talk to." + Reset)
+		case 1:
+			startConversation(names[0], "")
+		default:
+			startGroupConversation(names)
+		}
+	case VerbAsk:
+		askAbout(c.Arg)
+	case VerbWait:
+		playerState.Turn++
+		tickEffects()
+		advanceClock(3)
+		shiftWeather()
+		narrateTurn(fmt.Sprintf("I wait and linger at %s as time passes. Narrate what unfolds — perhaps someone arrives or the weather shifts.",
+			playerState.CurrentLocation))
+	case VerbRest:
+		takeRest()
+	case VerbSearch:
+		maybePrune()
+		searchArea(c.Raw, c.Arg)
+	case VerbTake:
+		if c.Arg == "" {
+			fmt.Println("Usage: take <item>")
+		} else {
+			takeItem(c.Raw, c.Arg)
+		}
+	case VerbLook:
+		narrateTurn(c.Raw)
+		printEnvironmentSummary(history)
+	case VerbExamine:
+		examine(c)
+	case VerbMove:
+		if strings.HasPrefix(c.Arg, "@") {
+			dest, ok := bookmarkDest(c.Arg[1:])
+			if !ok {
+				break
+			}
+			if dest == playerState.CurrentLocation {
+				fmt.Printf("You are already at %s.\n", dest)
+				break
+			}
+			c.Arg = dest
+		}
+		moveTo(c)
+	case VerbBookmark:
+		addBookmark(c.Arg)
+	case VerbBookmarks:
+		listBookmarks()
+	case VerbDistance:
+		showDistance(c.Arg)
+	case VerbAppearance:
+		describePlayer()
+	case VerbDo:
+		doAction(c.Arg)
+	case VerbGive:
+		giveItem(c.Arg)
+	case VerbForget:
+		forgetNpc(c.Arg)
+	case VerbNpcs:
+		npcsCmd(c.Arg)
+	case VerbNpc:
+		npcCmd(c.Arg)
+	case VerbParse:
+		explainCommand(c.Arg)
+	case VerbRenameLocation:
+		old, name, ok := splitRename(c.Arg)
+		if !ok {
+			fmt.Println("Usage: rename location <old> to <new>")
+			break
+		}
+		// a change of case alone finds old itself
+		if other := findLocation(name); other != "" && other != old {
+			fmt.Printf(Red+"There is already a place called %s."+Reset+"\n", name)
+			break
+		}
+		updated := renameLocation(old, name)
+		history = append(history, renameNote(old, name))
+		fmt.Printf(Yellow+"Renamed %s to %s (updated %s)."+Reset+"\n", old, name, strings.Join(updated, ", "))
+	case VerbRename:
+		if c.Arg == "" {
+			fmt.Println("Usage: rename <name>")
+			break
+		}
+		old := playerState.Name
+		playerState.Name = c.Arg
+		if old != "" {
+			history = append(history, Message{Role: "system", Content: fmt.Sprintf("The player character, formerly known as %s, now goes by %s.", old, c.Arg)})
+		}
+		fmt.Printf(Yellow+"You are now known as %s."+Reset+"\n", c.Arg)
+	default:
+		narrateTurn(c.Raw)
+	}
+	maybeAutosave(c.Verb)
+	return true, nil
+}
+
+// narrateTurn sends a player turn to the narrator, prints the reply and
+// records both in history
+func narrateTurn(content string) string {
+	resp, _ := narrateTurnChanges(content)
+	return resp
+}
+
+// narrateTurnChanges is narrateTurn, also applying any state markers in the
+// reply and returning the changes they made
+func narrateTurnChanges(content string) (string, []string) {
+	maybePrune()
+	history = append(history, Message{Role: "user", Content: content})
+	var resp string
+	var changes []string
+	if toolsEnabled {
+		resp, changes = narrateWithTools(withWorldContext(history))
+		var more []string
+		resp, more = applyMarkers(normalizeText(resp))
+		changes = append(changes, more...)
+	} else {
+		resp, changes = applyMarkers(normalizeText(callOpenAIOnHistory()))
+	}
+	resp = normalizeText(resp)
+	if resp == "" {
+		resp = emptyResponse
+	}
+	fmt.Println()
+	cw := newColorWriter(os.Stdout, Blue)
+	io.WriteString(cw, resp)
+	cw.Close()
+	fmt.Println()
+	for _, ch := range changes {
+		fmt.Println(Yellow + "[" + ch + "]" + Reset)
+	}
+	recordReply(resp)
+	regenReady = true
+	return resp, changes
+}
+
+// recordReply appends a narrator reply to history, leaving out empty replies
+// and stand-in messages so they don't pile up as blank turns
+func recordReply(resp string) {
+	if failedNarration(resp) {
+		return
+	}
+	history = append(history, Message{Role: "assistant", Content: resp})
+}
+
+// regenerate re-rolls the last narration from the same prompt at a higher
+// temperature, replacing it in history. Markers in the new text are stripped
+// rather than applied, since the original's already were.
+func regenerate() {
+	n := len(history)
+	if !regenReady || n < 2 || history[n-1].Role != "assistant" {
+		fmt.Println("There's no fresh narration to regenerate.")
+		return
+	}
+	old := history[n-1].Content
+	chatTemperature += 0.2
+	text := callOpenAI(withWorldContext(history[:n-1]))
+	chatTemperature -= 0.2
+	if failedNarration(text) {
+		fmt.Println(Red + "The narration could not be regenerated; keeping the original." + Reset)
+		return
+	}
+	text = normalizeText(markerRe.ReplaceAllString(normalizeText(text), ""))
+	history[n-1].Content = text
+	for loc, desc := range sceneDescriptions {
+		if desc == old {
+			sceneDescriptions[loc] = text
+		}
+	}
+	if showDiff {
+		fmt.Println()
+		fmt.Println(Dim + "Previous:\n" + old + Reset)
+	}
+	fmt.Println()
+	fmt.Println(Blue + text + Reset)
+}
+
+// markerContext teaches the narrator the state marker protocol
+const markerContext = "When the story changes the player's state, include markers in your reply: " +
+	"[INV+:<item>] when they gain an item, [INV-:<item>] when they lose or use one up, " +
+	"[GOLD+:<n>] or [GOLD-:<n>] for money, [STAT:<HP or stat>:<+n or -n>] for damage, healing or lasting changes, " +
+	"[SPELL:<name>] when they learn a spell, [REVEAL:<item>] when something hidden in the scene comes to light, " +
+	"[EFFECT:<name>:<HP lost per turn, or +n healed>:<turns>] for lasting conditions such as poison or burning, " +
+	"and [CURE:<name>] when a remedy, item or spell ends one. " +
+	"Only emit markers for things that actually happen."
+
+// toolContext replaces markerContext when -tools is set
+const toolContext = "When the story changes the player's state, call the provided tools to record it " +
+	"(items gained or lost, gold, damage, healing or lasting stat changes, conditions such as poison and their cures, " +
+	"or the player moving somewhere new), " +
+	"then narrate. Only record things that actually happen."
+
+// stateTools are the functions offered to tool-capable models
+var stateTools = []Tool{
+	{Type: "function", Function: ToolFunction{Name: "add_item", Description: "Give the player an item",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"}},"required":["name"]}`)}},
+	{Type: "function", Function: ToolFunction{Name: "remove_item", Description: "Take an item from the player's inventory",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"}},"required":["name"]}`)}},
+	{Type: "function", Function: ToolFunction{Name: "modify_gold", Description: "Add (positive) or spend (negative) gold",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"delta":{"type":"integer"}},"required":["delta"]}`)}},
+	{Type: "function", Function: ToolFunction{Name: "modify_stat", Description: "Change HP or one of the player's attributes by delta",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"stat":{"type":"string"},"delta":{"type":"integer"}},"required":["stat","delta"]}`)}},
+	{Type: "function", Function: ToolFunction{Name: "apply_effect", Description: "Give the player a lasting condition; delta is HP per turn (negative harms) for the given turns",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"},"delta":{"type":"integer"},"turns":{"type":"integer"}},"required":["name","delta","turns"]}`)}},
+	{Type: "function", Function: ToolFunction{Name: "cure_effect", Description: "End one of the player's conditions",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"}},"required":["name"]}`)}},
+	{Type: "function", Function: ToolFunction{Name: "move_player", Description: "Record that the player has moved to a named location",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"location":{"type":"string"}},"required":["location"]}`)}},
+}
+
+// maxToolRounds caps request/tool-result exchanges for one narration
+const maxToolRounds = 4
+
+// narrateWithTools requests narration with stateTools available, running
+// each tool call against playerState and feeding back the result until the
+// model replies with text
+func narrateWithTools(msgs []Message) (string, []string) {
+	if debugMode {
+		debugPrompt(msgs)
+	}
+	conv := append([]Message{}, msgs...)
+	var changes []string
+	for round := 0; round < maxToolRounds; round++ {
+		reply, reason, sent, err := sendChat(conv, stateTools, narrationTimeout)
+		conv = sent
+		lastCallErr = err
+		if len(reply.ToolCalls) == 0 {
+			return continueReply(conv, reply.Content, reason, narrationTimeout), changes
+		}
+		conv = append(conv, reply)
+		for _, call := range reply.ToolCalls {
+			result, change := runTool(call)
+			if change != "" {
+				changes = append(changes, change)
+			}
+			conv = append(conv, Message{Role: "tool", ToolCallID: call.ID, Content: result})
+		}
+	}
+	return emptyResponse, changes
+}
+
+// runTool applies one tool call, returning the result for the model and a
+// description of the change ("" if none)
+func runTool(call ToolCall) (string, string) {
+	var args struct {
+		Name     string `json:"name"`
+		Stat     string `json:"stat"`
+		Delta    int    `json:"delta"`
+		Turns    int    `json:"turns"`
+		Location string `json:"location"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return "error: invalid arguments", ""
+	}
+	var change string
+	switch call.Function.Name {
+	case "add_item":
+		if args.Name == "" {
+			return "error: name is required", ""
+		}
+		addItem(args.Name)
+		change = "Gained " + args.Name
+	case "remove_item":
+		it := removeItem(args.Name)
+		if it == "" {
+			return "error: the player has no " + args.Name, ""
+		}
+		change = "Lost " + it
+	case "modify_gold":
+		change = adjustGold(args.Delta)
+	case "modify_stat":
+		change = adjustStat(strings.ToUpper(args.Stat), args.Delta)
+		if change == "" {
+			return "error: unknown stat " + args.Stat, ""
+		}
+	case "apply_effect":
+		change = addEffect(args.Name, args.Delta, args.Turns)
+		if change == "" {
+			return "error: name, a non-zero delta and turns are required", ""
+		}
+	case "cure_effect":
+		change = cureEffect(args.Name)
+		if change == "" {
+			return "error: the player has no " + args.Name, ""
+		}
+	case "move_player":
+		dest := titleCase(args.Location)
+		if dest == "" {
+			return "error: location is required", ""
+		}
+		if dest != playerState.CurrentLocation {
+			enterLocation(dest)
+			change = "Moved to " + dest
+		}
+	default:
+		return "error: unknown tool " + call.Function.Name, ""
+	}
+	return "ok", change
+}
+
+// markerRe matches narrator state markers such as [INV+:torch], [GOLD-:5],
+// [STAT:HP:-3], [REP:TownGuard:+2] or [EFFECT:poison:2:3]; [ITEM:x] and
+// [HEAL:n] are older forms
+var markerRe = regexp.MustCompile(`\[(ITEM|HEAL|REP|INV[+-]|GOLD[+-]|STAT|SPELL|REVEAL|EFFECT|CURE):([^\]]*)\]`)
+
+// addEffect gives the player a condition, refreshing it if already present
+func addEffect(name string, delta, turns int) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || delta == 0 || turns <= 0 {
+		return ""
+	}
+	for i, e := range playerState.Effects {
+		if e.Name == name {
+			playerState.Effects[i] = Effect{Name: name, Delta: delta, Turns: max(turns, e.Turns)}
+			return fmt.Sprintf("Still %s (%d turns)", name, playerState.Effects[i].Turns)
+		}
+	}
+	playerState.Effects = append(playerState.Effects, Effect{Name: name, Delta: delta, Turns: turns})
+	return fmt.Sprintf("Now %s: HP %+d per turn for %d turns", name, delta, turns)
+}
+
+// cureEffect ends a condition, returning "" if the player didn't have it
+func cureEffect(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, e := range playerState.Effects {
+		if e.Name == name {
+			playerState.Effects = append(playerState.Effects[:i:i], playerState.Effects[i+1:]...)
+			return "Cured of " + name
+		}
+	}
+	return ""
+}
+
+// tickEffects applies one turn of every condition and drops those that
+// have run their course
+func tickEffects() {
+	var left []Effect
+	for _, e := range playerState.Effects {
+		fmt.Printf(Red+"%s: %s"+Reset+"\n", titleCase(e.Name), adjustHP(e.Delta))
+		if e.Turns--; e.Turns > 0 {
+			left = append(left, e)
+		} else {
+			fmt.Printf(Yellow+"The %s wears off."+Reset+"\n", e.Name)
+		}
+	}
+	playerState.Effects = left
+}
+
+// effectsSummary lists active conditions as "poison (-2/turn, 3 left)"
+func effectsSummary() string {
+	var parts []string
+	for _, e := range playerState.Effects {
+		parts = append(parts, fmt.Sprintf("%s (%+d/turn, %d left)", e.Name, e.Delta, e.Turns))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DC adjustment and damage multiplier (in percent) for each difficulty
+var (
+	difficultyDC     = map[string]int{"easy": -2, "normal": 0, "hard": 2}
+	difficultyDamage = map[string]int{"easy": 50, "normal": 100, "hard": 150}
+)
+
+// adjustDC applies the difficulty to a DC, returning it with a note such as
+// " (hard +2)" for roll output
+func adjustDC(dc int) (int, string) {
+	d := difficultyDC[playerState.Difficulty]
+	if d == 0 {
+		return dc, ""
+	}
+	return dc + d, fmt.Sprintf(" (%s %+d)", playerState.Difficulty, d)
+}
+
+// difficultyContext tells the narrator how hard to pitch challenges
+func difficultyContext() string {
+	switch playerState.Difficulty {
+	case "easy":
+		return "Difficulty: easy. Be forgiving; dangers are mild and help is near."
+	case "hard":
+		return "Difficulty: hard. Challenges are stern, foes dangerous and mistakes costly."
+	}
+	return ""
+}
+
+// adjustHP changes hit points within 0..MaxHP and describes the result
+func adjustHP(n int) string {
+	if n < 0 {
+		if pct, ok := difficultyDamage[playerState.Difficulty]; ok {
+			n = min(-1, n*pct/100)
+		}
+	}
+	before := playerState.HP
+	playerState.HP = max(0, min(playerState.HP+n, playerState.MaxHP))
+	if playerState.HP >= before {
+		return fmt.Sprintf("Healed %d HP (%d/%d)", playerState.HP-before, playerState.HP, playerState.MaxHP)
+	}
+	return fmt.Sprintf("Lost %d HP (%d/%d)", before-playerState.HP, playerState.HP, playerState.MaxHP)
+}
+
+// adjustGold adds n gold (never spending more than is carried) and describes
+// the result, or returns "" if nothing changed
+func adjustGold(n int) string {
+	n = max(n, -playerState.Gold)
+	if n == 0 {
+		return ""
+	}
+	playerState.Gold += n
+	return fmt.Sprintf("%+d gold (%d)", n, playerState.Gold)
+}
+
+// adjustStat changes HP or an attribute by n and describes the result, or
+// returns "" for an unknown stat
+func adjustStat(stat string, n int) string {
+	if stat == "HP" {
+		return adjustHP(n)
+	}
+	v, ok := playerState.Stats[stat]
+	if !ok || n == 0 {
+		return ""
+	}
+	playerState.Stats[stat] = max(1, v+n)
+	if stat == "STR" {
+		playerState.Capacity = baseCapacity()
+	}
+	return fmt.Sprintf("%s %+d (%d)", stat, n, playerState.Stats[stat])
+}
+
+// applyMarkers strips state markers from narration and applies them to the player
+func applyMarkers(text string) (string, []string) {
+	var changes []string
+	clean := markerRe.ReplaceAllStringFunc(text, func(m string) string {
+		parts := markerRe.FindStringSubmatch(m)
+		val := strings.TrimSpace(parts[2])
+		switch parts[1] {
+		case "ITEM", "INV+":
+			if val != "" {
+				addItem(val)
+				changes = append(changes, "Gained "+val)
+			}
+		case "INV-":
+			if it := removeItem(val); it != "" {
+				changes = append(changes, "Lost "+it)
+			}
+		case "HEAL":
+			if n, err := strconv.Atoi(val); err == nil && n > 0 {
+				changes = append(changes, adjustHP(n))
+			}
+		case "SPELL":
+			spell := strings.ToLower(val)
+			if spell != "" && !contains(playerState.Spells, spell) {
+				playerState.Spells = append(playerState.Spells, spell)
+				changes = append(changes, "Learned the spell "+spell)
+			}
+		case "REVEAL":
+			if val != "" {
+				loc := playerState.CurrentLocation
+				playerState.SceneItems[loc] = append(playerState.SceneItems[loc], val)
+				changes = append(changes, "Revealed "+val)
+			}
+		case "GOLD+", "GOLD-":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				break
+			}
+			if parts[1] == "GOLD-" {
+				n = -n
+			}
+			if ch := adjustGold(n); ch != "" {
+				changes = append(changes, ch)
+			}
+		case "STAT":
+			i := strings.LastIndex(val, ":")
+			if i <= 0 {
+				break
+			}
+			stat := strings.ToUpper(strings.TrimSpace(val[:i]))
+			n, err := strconv.Atoi(strings.TrimSpace(val[i+1:]))
+			if err != nil || n == 0 {
+				break
+			}
+			if ch := adjustStat(stat, n); ch != "" {
+				changes = append(changes, ch)
+			}
+		case "EFFECT":
+			f := strings.Split(val, ":")
+			if len(f) != 3 {
+				break
+			}
+			delta, err1 := strconv.Atoi(strings.TrimSpace(f[1]))
+			turns, err2 := strconv.Atoi(strings.TrimSpace(f[2]))
+			if err1 != nil || err2 != nil {
+				break
+			}
+			// a bare number is damage; healing over time is written +n
+			if !strings.HasPrefix(strings.TrimSpace(f[1]), "+") {
+				delta = -max(delta, -delta)
+			}
+			if ch := addEffect(f[0], delta, turns); ch != "" {
+				changes = append(changes, ch)
+			}
+		case "CURE":
+			if ch := cureEffect(val); ch != "" {
+				changes = append(changes, ch)
+			}
+		case "REP":
+			i := strings.LastIndex(val, ":")
+			if i <= 0 {
+				break
+			}
+			name := strings.TrimSpace(val[:i])
+			n, err := strconv.Atoi(strings.TrimSpace(val[i+1:]))
+			if err != nil || n == 0 {
+				break
+			}
+			if playerState.Reputation == nil {
+				playerState.Reputation = map[string]int{}
+			}
+			playerState.Reputation[name] += n
+			changes = append(changes, fmt.Sprintf("Reputation with %s %+d (%s)", name, n, reputationLabel(playerState.Reputation[name])))
+		}
+		return ""
+	})
+	return clean, changes
+}
+
+// doAction frames a freeform action for the narrator and records its outcome
+func doAction(action string) {
+	if action == "" {
+		fmt.Println("Usage: do <action>")
+		return
+	}
+	_, changes := narrateTurnChanges(fmt.Sprintf(
+		"I %s.\n(The player performs this action in the current scene. Narrate the attempt and its outcome. "+
+			"Use the state markers for anything they gain, lose or suffer.)", action))
+	if len(changes) > 0 {
+		playerState.Journal = append(playerState.Journal, fmt.Sprintf("Did '%s': %s.", action, strings.Join(changes, "; ")))
+	}
+}
+
+// setAlias lists aliases, or adds one and persists it to .advrc; with -s
+// the alias belongs to this save only
+func setAlias(arg string) {
+	parts := strings.Fields(arg)
+	if len(parts) == 0 {
+		keys := make([]string, 0, len(aliases)+len(playerState.Aliases))
+		for k := range aliases {
+			keys = append(keys, k)
+		}
+		for k := range playerState.Aliases {
+			if _, ok := aliases[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Println(Blue + "Aliases:" + Reset)
+		for _, k := range keys {
+			if v, ok := playerState.Aliases[k]; ok {
+				fmt.Printf(" %s = %s"+Dim+" (this save)"+Reset+"\n", k, v)
+			} else {
+				fmt.Printf(" %s = %s\n", k, aliases[k])
+			}
+		}
+		return
+	}
+	local := parts[0] == "-s"
+	if local {
+		parts = parts[1:]
+	}
+	if len(parts) < 2 {
+		fmt.Println("Usage: set alias [-s] <short> <command>")
+		return
+	}
+	short := strings.ToLower(parts[0])
+	exp := strings.Join(parts[1:], " ")
+	if local {
+		if playerState.Aliases == nil {
+			playerState.Aliases = map[string]string{}
+		}
+		playerState.Aliases[short] = exp
+		fmt.Printf("Alias '%s' now runs '%s' in this save.\n", short, exp)
+		return
+	}
+	aliases[short] = exp
+	if err := saveRC(); err != nil {
+		fmt.Fprintln(os.Stderr, "Config write error:", err)
+	}
+	fmt.Printf("Alias '%s' now runs '%s'.\n", short, exp)
+	if v, ok := playerState.Aliases[short]; ok {
+		fmt.Printf(Yellow+"This save's own alias '%s' = '%s' still takes precedence."+Reset+"\n", short, v)
+	}
+}
+
+// setPrune toggles history summarization
+func setPrune(arg string) {
+	if arg != "on" && arg != "off" {
+		fmt.Println("Usage: set prune on|off")
+		return
+	}
+	pruneEnabled = arg == "on"
+	state := "disabled"
+	if pruneEnabled {
+		state = "enabled"
+	}
+	fmt.Printf("History summarization %s.\n", state)
+}
+
+// rollCheck performs a d20 check against a stat, optionally versus a DC
+func rollCheck(c Command) {
+	if c.Arg == "" {
+		fmt.Println("Usage: roll <stat> [DC]")
+		return
+	}
+	val, ok := playerState.Stats[c.Arg]
+	if !ok {
+		fmt.Printf(Red+"Unknown stat '%s' (you have %s)."+Reset+"\n", c.Arg, strings.Join(playerState.StatNames, ", "))
+		return
+	}
+	mod := (val - 10) / 2
+	if encumbered() && (c.Arg == "STR" || c.Arg == "DEX") {
+		mod -= 2
+		fmt.Println(Red + "Your load weighs you down (-2)." + Reset)
+	}
+	die := rng.Intn(20) + 1
+	total := die + mod
+	result := fmt.Sprintf("Rolled 1d20 + %d = %d", mod, total)
+	if c.N > 0 {
+		dc, note := adjustDC(c.N)
+		outcome := "Failure"
+		if total >= dc {
+			outcome = "Success"
+		}
+		result += fmt.Sprintf(" vs DC %d%s: %s", dc, note, outcome)
+	}
+	fmt.Println(Yellow + result + Reset)
+}
+
+// The named checks new characters start with
+var standardChecks = map[string]string{
+	"perception": "WIS", "athletics": "STR", "stealth": "DEX",
+	"persuasion": "CHA", "investigation": "INT", "endurance": "CON",
+}
+
+// defaultChecks returns the standard checks whose stat is in the set
+func defaultChecks(stats []string) map[string]SkillCheck {
+	checks := map[string]SkillCheck{}
+	for name, stat := range standardChecks {
+		if contains(stats, stat) {
+			checks[name] = SkillCheck{Stat: stat}
+		}
+	}
+	return checks
+}
+
+// setCheck defines a named check: "<name> <stat> [DC]"
+func setCheck(arg string) {
+	f := strings.Fields(arg)
+	if len(f) < 2 || len(f) > 3 {
+		fmt.Println("Usage: set check <name> <stat> [DC]")
+		return
+	}
+	name, stat := strings.ToLower(f[0]), strings.ToUpper(f[1])
+	if _, ok := playerState.Stats[stat]; !ok {
+		fmt.Printf(Red+"Unknown stat '%s' (you have %s)."+Reset+"\n", stat, strings.Join(playerState.StatNames, ", "))
+		return
+	}
+	sc := SkillCheck{Stat: stat}
+	if len(f) == 3 {
+		dc, err := strconv.Atoi(f[2])
+		if err != nil || dc < 1 {
+			fmt.Println("Usage: set check <name> <stat> [DC]")
+			return
+		}
+		sc.DC = dc
+	}
+	if playerState.Checks == nil {
+		playerState.Checks = map[string]SkillCheck{}
+	}
+	playerState.Checks[name] = sc
+	if sc.DC > 0 {
+		fmt.Printf("Check '%s' now rolls %s against DC %d.\n", name, stat, sc.DC)
+	} else {
+		fmt.Printf("Check '%s' now rolls %s.\n", name, stat)
+	}
+}
+
+// runCheck rolls a named check, or lists them; a DC given here overrides
+// the check's default
+func runCheck(arg string) {
+	f := strings.Fields(strings.ToLower(arg))
+	if len(f) == 0 {
+		names := make([]string, 0, len(playerState.Checks))
+		for n := range playerState.Checks {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Println(Blue + "Checks:" + Reset)
+		for _, n := range names {
+			sc := playerState.Checks[n]
+			if sc.DC > 0 {
+				fmt.Printf(" %-14s %s, DC %d\n", n, sc.Stat, sc.DC)
+			} else {
+				fmt.Printf(" %-14s %s\n", n, sc.Stat)
 			}
-			history = append(history, Message{Role: "user", Content: cmd})
-			resp := normalizeText(callOpenAI(history))
-			fmt.Println()
-			fmt.Println(Blue + resp + Reset)
-			history = append(history, Message{Role: "assistant", Content: resp})
-			sceneDescriptions[dest] = resp
-			printEnvironmentSummary(history)
+		}
+		return
+	}
+	sc, ok := playerState.Checks[f[0]]
+	if !ok {
+		fmt.Printf(Red+"No check called '%s'. Define one with: set check %s <stat> [DC]"+Reset+"\n", f[0], f[0])
+		return
+	}
+	dc := sc.DC
+	if len(f) > 1 {
+		if n, err := strconv.Atoi(f[1]); err == nil {
+			dc = n
+		}
+	}
+	fmt.Printf(Dim+"%s (%s)"+Reset+"\n", titleCase(f[0]), sc.Stat)
+	rollCheck(Command{Verb: VerbRoll, Arg: sc.Stat, N: dc})
+}
+
+// baseMana derives daily spell slots from the better of INT and WIS
+func baseMana() int {
+	return max(1, 1+perceptionMod())
+}
+
+// hpBar draws hit points as a ten-segment bar
+func hpBar(hp, maxHP int) string {
+	if maxHP <= 0 {
+		return "—"
+	}
+	filled := max(0, min(10, hp*10/maxHP))
+	color := Green
+	if hp*3 <= maxHP {
+		color = Red
+	} else if hp*3 <= maxHP*2 {
+		color = Yellow
+	}
+	return color + strings.Repeat("█", filled) + Reset + Dim + strings.Repeat("░", 10-filled) + Reset + fmt.Sprintf(" %d/%d", hp, maxHP)
+}
+
+// showStatus prints a compact overview of the player's state
+func showStatus() {
+	name := playerState.Name
+	if name == "" {
+		name = tr("Unnamed traveler")
+	}
+	class := playerState.Class
+	if class == "" {
+		class = "Adventurer"
+	}
+	row := func(label, value string) {
+		fmt.Printf(Yellow+"%-10s"+Reset+" %s\n", tr(label)+":", value)
+	}
+	fmt.Println(Blue + name + ", " + class + Reset)
+	row("HP", hpBar(playerState.HP, playerState.MaxHP))
+	var stats []string
+	for _, k := range playerState.StatNames {
+		if v, ok := playerState.Stats[k]; ok {
+			stats = append(stats, fmt.Sprintf("%s %2d", k, v))
+		}
+	}
+	row("Stats", strings.Join(stats, "  "))
+	row("Gold", strconv.Itoa(playerState.Gold))
+	load := "—"
+	if playerState.Capacity > 0 {
+		load = fmt.Sprintf("%d/%d", carriedWeight(), playerState.Capacity)
+		if encumbered() {
+			load += Red + " " + tr("(over-encumbered)") + Reset
+		}
+	}
+	row("Load", load)
+	if travelCost > 0 {
+		row("Stamina", fmt.Sprintf("%d/%d", playerState.Stamina, playerState.MaxStamina))
+	}
+	if playerState.MaxMana > 0 && len(playerState.Spells) > 0 {
+		row("Slots", fmt.Sprintf("%d/%d", playerState.Mana, playerState.MaxMana))
+	}
+	if len(playerState.Effects) > 0 {
+		row("Effects", effectsSummary())
+	}
+	loc := playerState.CurrentLocation
+	if loc == "" {
+		loc = "—"
+	}
+	row("Location", fmt.Sprintf("%s (Day %d, %s, %s)", loc, max(1, playerState.Day), timeOfDay(playerState.Hour), playerState.Weather))
+	row("Journal", fmt.Sprintf(tr("%d entries"), len(playerState.Journal)))
+	row("NPCs", fmt.Sprintf(tr("%d known"), len(npcData)))
+}
+
+// showSpells lists known spells and remaining spell slots
+func showSpells() {
+	if len(playerState.Spells) == 0 {
+		fmt.Println("You know no spells. Perhaps someone could teach you, or a book could.")
+		return
+	}
+	fmt.Printf(Magenta+"Spells:"+Reset+" %s\n", strings.Join(playerState.Spells, ", "))
+	fmt.Printf(Magenta+"Slots:"+Reset+" %d/%d (restored each dawn)\n", playerState.Mana, playerState.MaxMana)
+}
+
+// castSpell spends a slot and rolls INT or WIS against a DC, letting the
+// narrator apply the effect through state markers
+func castSpell(spell string) {
+	if spell == "" {
+		fmt.Println("Usage: cast <spell>")
+		return
+	}
+	spell = strings.ToLower(spell)
+	if !contains(playerState.Spells, spell) {
+		fmt.Printf(Red+"You don't know the spell '%s'."+Reset+"\n", spell)
+		return
+	}
+	if playerState.Mana <= 0 {
+		fmt.Println(Red + "You have no spell slots left; they return at dawn." + Reset)
+		return
+	}
+	playerState.Mana--
+	dc, note := adjustDC(askDC(history, "cast the spell "+spell))
+	mod := perceptionMod()
+	die := rng.Intn(20) + 1
+	total := die + mod
+	outcome := "Failure"
+	if total >= dc {
+		outcome = "Success"
+	}
+	fmt.Println(Yellow + fmt.Sprintf("Spellcasting: rolled 1d20 + %d = %d vs DC %d%s: %s (%d/%d slots left)",
+		mod, total, dc, note, outcome, playerState.Mana, playerState.MaxMana) + Reset)
+	narrateTurnChanges(fmt.Sprintf("I cast %s.\n(The casting was a %s. Narrate the effect; on success, apply it with state markers, "+
+		"e.g. [STAT:HP:+n] for healing or [REVEAL:<item>] for anything hidden that light or sight magic uncovers.)", spell, strings.ToLower(outcome)))
+}
+
+// showMap prints the visited list, ASCII map and details for a location
+func showMap(target string) {
+	if target == "" {
+		target = playerState.CurrentLocation
+	}
+	fmt.Printf(Blue+"Map for '%s':"+Reset+"\n", target)
+	if len(playerState.VisitedLocations) > 0 {
+		fmt.Printf(Yellow+"Visited:"+Reset+" %s\n", strings.Join(playerState.VisitedLocations, ", "))
+	} else {
+		fmt.Print(Yellow + "No visited locations yet." + Reset + "\n")
+	}
+	if _, ok := playerState.MapGraph[target]; !ok && len(playerState.Frontiers[target]) == 0 {
+		fmt.Printf(Yellow+"No map connections for '%s'."+Reset+"\n", target)
+	} else {
+		drawMap(target, "", "", true, nil)
+	}
+	if desc, ex := sceneDescriptions[target]; ex {
+		fmt.Printf("\n"+Green+"Details for '%s':"+Reset+"\n", target)
+		for _, line := range strings.Split(desc, "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+}
+
+// dotQuote quotes a name as a DOT string
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// exportMap writes the map as a Graphviz DOT graph: the current location is
+// filled, visited places are solid and unexplored frontiers dashed
+func exportMap(path string) error {
+	nodes := map[string]bool{}
+	for a, ns := range playerState.MapGraph {
+		nodes[a] = true
+		for b := range ns {
+			nodes[b] = true
+		}
+	}
+	for _, v := range playerState.VisitedLocations {
+		nodes[v] = true
+	}
+	if playerState.CurrentLocation != "" {
+		nodes[playerState.CurrentLocation] = true
+	}
+	var frontier [][2]string
+	for from, fs := range playerState.Frontiers {
+		for f := range fs {
+			if !nodes[f] {
+				frontier = append(frontier, [2]string{from, f})
+			}
+		}
+	}
+	names := make([]string, 0, len(nodes))
+	for n := range nodes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	sort.Slice(frontier, func(i, j int) bool {
+		if frontier[i][0] != frontier[j][0] {
+			return frontier[i][0] < frontier[j][0]
+		}
+		return frontier[i][1] < frontier[j][1]
+	})
+
+	var b strings.Builder
+	b.WriteString("graph adventure {\n\tnode [shape=box];\n")
+	for _, n := range names {
+		switch {
+		case n == playerState.CurrentLocation:
+			fmt.Fprintf(&b, "\t%s [style=\"filled,bold\", fillcolor=gold];\n", dotQuote(n))
+		case contains(playerState.VisitedLocations, n):
+			fmt.Fprintf(&b, "\t%s;\n", dotQuote(n))
+		default:
+			fmt.Fprintf(&b, "\t%s [color=gray50];\n", dotQuote(n))
+		}
+	}
+	seen := map[string]bool{}
+	for _, f := range frontier {
+		if !seen[f[1]] {
+			seen[f[1]] = true
+			fmt.Fprintf(&b, "\t%s [style=dashed, color=gray50, fontcolor=gray50];\n", dotQuote(f[1]))
+		}
+	}
+	for _, a := range names {
+		neighbors := make([]string, 0)
+		for n := range playerState.MapGraph[a] {
+			// the graph is undirected, so each edge is written once
+			if a < n {
+				neighbors = append(neighbors, n)
+			}
+		}
+		sort.Strings(neighbors)
+		for _, n := range neighbors {
+			fmt.Fprintf(&b, "\t%s -- %s;\n", dotQuote(a), dotQuote(n))
+		}
+	}
+	for _, f := range frontier {
+		fmt.Fprintf(&b, "\t%s -- %s [style=dashed, color=gray50];\n", dotQuote(f[0]), dotQuote(f[1]))
+	}
+	b.WriteString("}\n")
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// Directions that can be peeked at with look <direction>
+var directions = map[string]bool{"north": true, "south": true, "east": true, "west": true,
+	"northeast": true, "northwest": true, "southeast": true, "southwest": true, "up": true, "down": true}
+
+// placePrefix marks the line naming a place seen while peeking
+const placePrefix = "PLACE: "
+
+// peek describes what lies in a direction without moving there
+func peek(c Command) {
+	loc := playerState.CurrentLocation
+	maybePrune()
+	history = append(history, Message{Role: "user", Content: fmt.Sprintf(
+		"Without leaving %s, I look %s.\n(Describe only what can be seen or heard in that direction from here; "+
+			"the player does not move. If a distinct named place lies that way, end with a line '%s<name>'.)",
+		loc, c.Arg, placePrefix)})
+	resp, _ := applyMarkers(normalizeText(callOpenAIOnHistory()))
+	var place string
+	var lines []string
+	for _, line := range strings.Split(resp, "\n") {
+		if strings.HasPrefix(line, placePrefix) {
+			place = titleCase(strings.Trim(strings.TrimPrefix(line, placePrefix), " .!"))
+			continue
+		}
+		lines = append(lines, line)
+	}
+	resp = normalizeText(strings.Join(lines, "\n"))
+	if resp == "" {
+		resp = emptyResponse
+	}
+	fmt.Println()
+	fmt.Println(Blue + resp + Reset)
+	recordReply(resp)
+	if place == "" || place == loc || contains(playerState.VisitedLocations, place) || playerState.Frontiers[loc][place] {
+		return
+	}
+	if confirm(fmt.Sprintf("Mark %s to the %s on your map?", place, c.Arg)) {
+		recordFrontier(loc, place)
+	}
+}
+
+// recordFrontier notes a known but unvisited place reachable from loc
+func recordFrontier(loc, place string) {
+	if playerState.Frontiers[loc] == nil {
+		playerState.Frontiers[loc] = map[string]bool{}
+	}
+	playerState.Frontiers[loc][place] = true
+}
+
+// maxPathHistory caps how many steps the trail remembers
+const maxPathHistory = 50
+
+// addToPath appends a step to the trail, dropping the oldest past the cap
+func addToPath(loc string) {
+	playerState.PathHistory = append(playerState.PathHistory, loc)
+	if n := len(playerState.PathHistory); n > maxPathHistory {
+		playerState.PathHistory = playerState.PathHistory[n-maxPathHistory:]
+	}
+}
+
+// findLocation returns the known place whose name matches, ignoring case
+// and a leading article ("the Tower" is "Tower"), or ""
+func findLocation(name string) string {
+	places := append([]string{playerState.CurrentLocation}, playerState.VisitedLocations...)
+	for p, ns := range playerState.MapGraph {
+		places = append(places, p)
+		for n := range ns {
+			places = append(places, n)
+		}
+	}
+	for _, fs := range playerState.Frontiers {
+		for f := range fs {
+			places = append(places, f)
+		}
+	}
+	for _, p := range places {
+		if p != "" && strings.EqualFold(p, name) {
+			return p
+		}
+	}
+	bare := stripArticle(name)
+	for _, p := range places {
+		if p != "" && strings.EqualFold(stripArticle(p), bare) {
+			return p
+		}
+	}
+	return ""
+}
+
+// stripArticle drops a leading "the", "a" or "an" from a name
+func stripArticle(name string) string {
+	name = strings.TrimSpace(name)
+	for _, a := range []string{"the ", "a ", "an "} {
+		if len(name) > len(a) && strings.EqualFold(name[:len(a)], a) {
+			return strings.TrimSpace(name[len(a):])
+		}
+	}
+	return name
+}
+
+// showDistance prints the shortest known route for "<A> to <B>", or from
+// the current location to a single place
+func showDistance(arg string) {
+	from, to := playerState.CurrentLocation, ""
+	if a, b, ok := splitAtTo(arg, func(left, right string) bool {
+		return findLocation(left) != "" && findLocation(right) != ""
+	}); ok {
+		from, to = findLocation(a), findLocation(b)
+	} else {
+		to = findLocation(arg)
+	}
+	if arg == "" || from == "" {
+		fmt.Println("Usage: distance [<place> to] <place>")
+		return
+	}
+	if to == "" {
+		fmt.Printf("Your map has no place called %s.\n", arg)
+		return
+	}
+	path := shortestPath(from, to)
+	switch {
+	case path == nil:
+		fmt.Printf("No known route from %s to %s.\n", from, to)
+	case len(path) == 1:
+		fmt.Printf("You are already at %s.\n", from)
+	default:
+		moves := "moves"
+		if len(path) == 2 {
+			moves = "move"
+		}
+		fmt.Printf(Yellow+"%s to %s: %d %s"+Reset+"\n  %s\n", from, to, len(path)-1, moves, strings.Join(path, " → "))
+	}
+}
+
+// splitAtTo splits arg at the first " to ", in any case, whose two sides
+// accept takes, so names like "Road to Town" can still be given
+func splitAtTo(arg string, accept func(left, right string) bool) (string, string, bool) {
+	for i := 0; i+len(" to ") <= len(arg); i++ {
+		if !strings.EqualFold(arg[i:i+len(" to ")], " to ") {
 			continue
 		}
-		// default forward
-		history = append(history, Message{Role: "user", Content: cmd})
-		resp := normalizeText(callOpenAI(history))
+		left, right := strings.TrimSpace(arg[:i]), strings.TrimSpace(arg[i+len(" to "):])
+		if accept(left, right) {
+			return left, right, true
+		}
+	}
+	return "", "", false
+}
+
+// splitRename splits "<old> to <new>", choosing the " to " that leaves a
+// known place on the left
+func splitRename(arg string) (string, string, bool) {
+	old, name, ok := splitAtTo(arg, func(left, right string) bool {
+		return findLocation(left) != "" && right != ""
+	})
+	if !ok {
+		return "", "", false
+	}
+	return findLocation(old), name, true
+}
+
+// renameLocation replaces old with name in every place a location is
+// recorded and lists what it touched
+func renameLocation(old, name string) []string {
+	var updated []string
+	if playerState.CurrentLocation == old {
+		playerState.CurrentLocation = name
+		updated = append(updated, "current location")
+	}
+	if lastItemsLoc == old {
+		lastItemsLoc = name
+	}
+	if lastNpcsLoc == old {
+		lastNpcsLoc = name
+	}
+	for i, v := range playerState.VisitedLocations {
+		if v == old {
+			playerState.VisitedLocations[i] = name
+			updated = append(updated, "visited places")
+		}
+	}
+	trail := false
+	for i, v := range playerState.PathHistory {
+		if v == old {
+			playerState.PathHistory[i] = name
+			trail = true
+		}
+	}
+	if trail {
+		updated = append(updated, "trail")
+	}
+	renameKey := func(m map[string]map[string]bool) bool {
+		changed := false
+		if ns, ok := m[old]; ok {
+			delete(m, old)
+			m[name] = ns
+			changed = true
+		}
+		for _, ns := range m {
+			if ns[old] {
+				delete(ns, old)
+				ns[name] = true
+				changed = true
+			}
+		}
+		return changed
+	}
+	if renameKey(playerState.MapGraph) {
+		updated = append(updated, "map")
+	}
+	if renameKey(playerState.Frontiers) {
+		updated = append(updated, "frontiers")
+	}
+	if items, ok := playerState.SceneItems[old]; ok {
+		delete(playerState.SceneItems, old)
+		playerState.SceneItems[name] = items
+		updated = append(updated, "scene items")
+	}
+	if desc, ok := sceneDescriptions[old]; ok {
+		delete(sceneDescriptions, old)
+		sceneDescriptions[name] = desc
+		updated = append(updated, "scene description")
+	}
+	if line, ok := ambientLines[old]; ok {
+		delete(ambientLines, old)
+		ambientLines[name] = line
+	}
+	for label, loc := range playerState.Bookmarks {
+		if loc == old {
+			playerState.Bookmarks[label] = name
+			updated = append(updated, "bookmark @"+label)
+		}
+	}
+	if len(updated) == 0 {
+		updated = append(updated, "nothing else")
+	}
+	return updated
+}
+
+// addBookmark labels the current location for 'go to @label'
+func addBookmark(label string) {
+	label = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(label), "@"))
+	if label == "" || strings.ContainsAny(label, " \t") {
+		fmt.Println("Usage: bookmark <label> (one word)")
+		return
+	}
+	loc := playerState.CurrentLocation
+	if loc == "" {
+		fmt.Println("You aren't anywhere yet.")
+		return
+	}
+	if playerState.Bookmarks == nil {
+		playerState.Bookmarks = map[string]string{}
+	}
+	if prev, ok := playerState.Bookmarks[label]; ok && prev != loc {
+		fmt.Printf(Dim+"(@%s was %s)"+Reset+"\n", label, prev)
+	}
+	playerState.Bookmarks[label] = loc
+	fmt.Printf(Yellow+"Bookmarked %s as @%s."+Reset+"\n", loc, label)
+}
+
+// listBookmarks prints bookmark labels and their places
+func listBookmarks() {
+	if len(playerState.Bookmarks) == 0 {
+		fmt.Println("No bookmarks yet. Use 'bookmark <label>' to mark where you are.")
+		return
+	}
+	var labels []string
+	for l := range playerState.Bookmarks {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Printf("  @%-12s %s\n", l, playerState.Bookmarks[l])
+	}
+}
+
+// bookmarkDest looks up a bookmark label, reporting an unknown one
+func bookmarkDest(label string) (string, bool) {
+	loc, ok := playerState.Bookmarks[strings.ToLower(strings.TrimSpace(label))]
+	if !ok {
+		fmt.Printf(Red+"No bookmark @%s. Use 'bookmarks' to list them."+Reset+"\n", label)
+	}
+	return loc, ok
+}
+
+// showTrail prints the locations walked through, highlighting the current one
+func showTrail() {
+	if len(playerState.PathHistory) == 0 {
+		fmt.Println("You haven't gone anywhere yet.")
+		return
+	}
+	steps := make([]string, len(playerState.PathHistory))
+	copy(steps, playerState.PathHistory)
+	steps[len(steps)-1] = Green + "[" + steps[len(steps)-1] + "]" + Reset
+	fmt.Println(Blue + "Trail:" + Reset + " " + strings.Join(steps, " → "))
+}
+
+// goBack walks one step back along the trail; the step is dropped from the
+// trail so repeated backs keep retreating rather than bouncing
+func goBack() {
+	n := len(playerState.PathHistory)
+	if n < 2 {
+		fmt.Println("There's nowhere to go back to yet.")
+		return
+	}
+	prev := playerState.PathHistory[n-2]
+	if exhausted(travelStamina(prev)) {
+		return
+	}
+	playerState.PathHistory = playerState.PathHistory[:n-2]
+	moveTo(Command{Verb: VerbMove, Arg: prev, Raw: "go back to " + prev})
+}
+
+// moveTo travels to c.Arg, linking it to the previous location on the map
+func moveTo(c Command) {
+	dest := c.Arg
+	cost := travelStamina(dest)
+	if exhausted(cost) {
+		return
+	}
+	playerState.Stamina -= cost
+	enterLocation(dest)
+	cached, seen := sceneDescriptions[dest]
+	if seen && persistentScenes {
 		fmt.Println()
-		fmt.Println(Blue + resp + Reset)
-		history = append(history, Message{Role: "assistant", Content: resp})
+		fmt.Printf(Green+"You return to %s."+Reset+"\n", dest)
+		fmt.Println(Blue + cached + Reset)
+		history = append(history, Message{Role: "user", Content: c.Raw},
+			Message{Role: "assistant", Content: fmt.Sprintf("You return to %s.\n%s", dest, cached)})
+		printAmbient(dest)
+		maybeEncounter()
+		return
+	}
+	resp := narrateTurn(c.Raw)
+	for !seen && failedNarration(resp) && confirm("The scene failed to generate. Try again?") {
+		// a failed reply isn't recorded, so only the turn itself is dropped
+		if n := len(history); n > 0 && history[n-1].Role == "user" {
+			history = history[:n-1]
+		}
+		resp = narrateTurn(c.Raw)
+	}
+	if !failedNarration(resp) {
+		sceneDescriptions[dest] = resp
+		checkLocation(dest)
+		printAmbient(playerState.CurrentLocation)
+		maybeEncounter()
+	}
+	printEnvironmentSummary(history)
+}
+
+// travelCost is the stamina spent per move; 0 turns stamina off
+var travelCost = 1
+
+// travelStamina is what reaching dest costs: one move's worth per step of
+// the shortest known route, so fast travel across the map tires the player
+func travelStamina(dest string) int {
+	if travelCost <= 0 {
+		return 0
+	}
+	hops := 1
+	if path := shortestPath(playerState.CurrentLocation, dest); len(path) > 2 {
+		hops = len(path) - 1
+	}
+	return hops * travelCost
+}
+
+// exhausted reports, with a message, whether the player lacks the stamina
+// for a trip costing cost
+func exhausted(cost int) bool {
+	if cost == 0 || playerState.Stamina >= cost {
+		return false
+	}
+	if playerState.Stamina == 0 {
+		fmt.Println(Yellow + "You are too exhausted to go on. Rest first." + Reset)
+	} else {
+		fmt.Printf(Yellow+"You are too tired to travel that far (it would take %d stamina; you have %d). Rest first, or go somewhere nearer."+Reset+"\n", cost, playerState.Stamina)
+	}
+	return true
+}
+
+// shortestPath finds the fewest-moves route between two places on the map,
+// both ends included, or nil if none is known
+func shortestPath(from, to string) []string {
+	if from == to {
+		return []string{from}
+	}
+	prev := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		var next []string
+		for n := range playerState.MapGraph[cur] {
+			next = append(next, n)
+		}
+		sort.Strings(next)
+		for _, n := range next {
+			if _, seen := prev[n]; seen {
+				continue
+			}
+			prev[n] = cur
+			if n == to {
+				path := []string{to}
+				for p := cur; p != ""; p = prev[p] {
+					path = append([]string{p}, path...)
+				}
+				return path
+			}
+			queue = append(queue, n)
+		}
+	}
+	return nil
+}
+
+// takeRest passes a few hours recovering, restoring stamina
+func takeRest() {
+	playerState.Turn++
+	tickEffects()
+	advanceClock(4)
+	shiftWeather()
+	playerState.Stamina = playerState.MaxStamina
+	narrateTurn(fmt.Sprintf("I stop to rest at %s for a few hours. Narrate the rest briefly and leave me refreshed.",
+		playerState.CurrentLocation))
+	if travelCost > 0 {
+		fmt.Printf(Yellow+"[Stamina restored: %d/%d]"+Reset+"\n", playerState.Stamina, playerState.MaxStamina)
+	}
+}
+
+// encounterChance is the base percent chance of a random encounter on
+// entering a place; notoriety raises it and 0 turns encounters off
+var encounterChance = 10
+
+// Random encounters by how the player is regarded overall
+var (
+	famousEncounters   = []string{"an admirer who has heard of the player's deeds", "a petitioner seeking the player's help", "a local eager to share rumors"}
+	infamousEncounters = []string{"a bounty hunter on the player's trail", "a pickpocket who has marked the player", "a thug looking to settle a score"}
+	quietEncounters    = []string{"a pickpocket working the crowd", "a lost traveler asking the way", "a peddler with odd wares"}
+)
+
+// maybeEncounter rolls for a random encounter as the player arrives; the
+// more notorious they are, good or bad, the likelier one is
+func maybeEncounter() {
+	if encounterChance <= 0 {
+		return
+	}
+	notoriety, standing := 0, 0
+	for _, v := range playerState.Reputation {
+		notoriety += max(v, -v)
+		standing += v
+	}
+	chance := min(50, encounterChance+2*notoriety)
+	roll := rng.Intn(100)
+	if debugMode {
+		fmt.Fprintf(os.Stderr, Dim+"[debug] encounter roll %d vs %d%% (notoriety %d)"+Reset+"\n", roll, chance, notoriety)
+	}
+	if roll >= chance {
+		return
+	}
+	pool := quietEncounters
+	switch {
+	case notoriety >= 3 && standing > 0:
+		pool = famousEncounters
+	case notoriety >= 3 && standing < 0:
+		pool = infamousEncounters
+	}
+	who := pool[rng.Intn(len(pool))]
+	loc := playerState.CurrentLocation
+	fmt.Println()
+	narrateTurnChanges(fmt.Sprintf("(Something happens as I arrive at %s.)\n(A random encounter: %s approaches the player. "+
+		"Describe the encounter in a few sentences and leave the player free to respond. Use state markers only for what actually changes hands.)", loc, who))
+	playerState.Journal = append(playerState.Journal, fmt.Sprintf("Encountered %s at %s.", who, loc))
+}
+
+// checkLocation asks the narrator whether its scene is really at loc and,
+// when it clearly says otherwise, renames the place to match the story
+func checkLocation(loc string) {
+	prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+		"According to your last narration, is the player now at %s? Reply in exactly this form: "+
+			"'yes | <place name>' or 'no | <place name>', giving the short proper name of where the player actually is.", loc)})
+	raw := strings.TrimSpace(callOpenAIQuick(prompt))
+	answer, name, ok := strings.Cut(raw, "|")
+	if !ok {
+		return
+	}
+	answer = strings.ToLower(strings.Trim(strings.TrimSpace(answer), "'\".!"))
+	name = strings.Trim(strings.TrimSpace(name), "'\".!")
+	if answer != "no" || name == "" || len(strings.Fields(name)) > maxNameWords {
+		return
+	}
+	ln, ll := strings.ToLower(name), strings.ToLower(loc)
+	if strings.Contains(ln, ll) || strings.Contains(ll, ln) {
+		return
+	}
+	if other := findLocation(name); other != "" {
+		fmt.Printf(Yellow+"Warning: the story places you at %s, but the map has you in %s."+Reset+"\n", other, loc)
+		return
+	}
+	renameLocation(loc, name)
+	history = append(history, renameNote(loc, name))
+	fmt.Printf(Yellow+"Warning: the story places you at %s rather than %s; the map now calls it %s."+Reset+"\n", name, loc, name)
+}
+
+// renameNote tells the narrator that a place now goes by another name
+func renameNote(old, name string) Message {
+	return Message{Role: "system", Content: fmt.Sprintf("The place formerly called %s is now called %s.", old, name)}
+}
+
+// enterLocation does the bookkeeping of travel: the map edge, trail, clock,
+// weather and visited list
+func enterLocation(dest string) {
+	prev := playerState.CurrentLocation
+	if prev != "" {
+		if playerState.MapGraph[prev] == nil {
+			playerState.MapGraph[prev] = map[string]bool{}
+		}
+		if playerState.MapGraph[dest] == nil {
+			playerState.MapGraph[dest] = map[string]bool{}
+		}
+		playerState.MapGraph[prev][dest] = true
+		playerState.MapGraph[dest][prev] = true
+	}
+	playerState.CurrentLocation = dest
+	lastNpcs, lastNpcsLoc = nil, ""
+	addToPath(dest)
+	// reaching a frontier promotes it to a visited node
+	for _, f := range playerState.Frontiers {
+		delete(f, dest)
+	}
+	advanceClock(1)
+	shiftWeather()
+	if !contains(playerState.VisitedLocations, dest) {
+		playerState.VisitedLocations = append(playerState.VisitedLocations, dest)
+	}
+}
+
+// printAmbient prints a location's ambient line, generating it on first visit
+func printAmbient(loc string) {
+	if !ambientEnabled {
+		return
+	}
+	line, ok := ambientLines[loc]
+	if !ok {
+		prompt := append(history, Message{Role: "user", Content: fmt.Sprintf(
+			"Give one short line of ambient sound or atmosphere for %s, such as 'Somewhere, a bell tolls.' Reply with only that line.", loc)})
+		line = strings.Trim(normalizeText(callOpenAI(prompt)), "\"")
+		if failedNarration(line) {
+			return
+		}
+		ambientLines[loc] = line
 	}
+	fmt.Println(Dim + line + Reset)
 }
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc lets a plain function stand in for httpClient's transport
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// stubReply is one canned API response
+type stubReply struct {
+	status int
+	body   string
+}
+
+// stubAPI answers requests with replies in turn, repeating the last one, and
+// returns the requests it saw. Retries don't wait while it's installed.
+func stubAPI(t *testing.T, replies ...stubReply) *[]*http.Request {
+	t.Helper()
+	oldClient, oldDelay, oldRetries := httpClient, retryDelay, maxRetries
+	t.Cleanup(func() { httpClient, retryDelay, maxRetries = oldClient, oldDelay, oldRetries })
+	retryDelay, maxRetries = 0, 2
+	var seen []*http.Request
+	httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		rep := replies[min(len(seen), len(replies)-1)]
+		seen = append(seen, r)
+		return &http.Response{
+			StatusCode: rep.status,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(rep.body)),
+			Request:    r,
+		}, nil
+	})}
+	return &seen
+}
+
+func TestLongSessionStaysBounded(t *testing.T) {
+	oldHist, oldBudget, oldTail := history, pruneTokens, pruneTailTokens
+	t.Cleanup(func() { history, pruneTokens, pruneTailTokens = oldHist, oldBudget, oldTail })
+	seen := stubAPI(t, stubReply{200, `{"choices":[{"message":{"role":"assistant","content":"The hero wandered far."}}]}`})
+	history = []Message{{Role: "system", Content: "You are the narrator."}}
+	pruneTokens, pruneTailTokens = 1500, 500
+	for turn := 0; turn < 200; turn++ {
+		history = append(history,
+			Message{Role: "user", Content: strings.Repeat("walk on ", 25)},
+			Message{Role: "assistant", Content: strings.Repeat("The road goes ever on. ", 35)})
+		history = pruneHistory(history)
+		if n := historyTokens(history); n > pruneTokens {
+			t.Fatalf("turn %d: history is %d tokens, over the %d budget", turn, n, pruneTokens)
+		}
+	}
+	if len(*seen) == 0 {
+		t.Fatal("the summarizer was never called")
+	}
+	if history[0].Content != "You are the narrator." || !isSummary(history[1]) {
+		t.Errorf("history should open with the system prompt and the summary, got %q, %q", history[0].Content, history[1].Content)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		verb Verb
+		arg  string
+		n    int
+	}{
+		{"go to the Tower", VerbMove, "The Tower", 0},
+		{"north", VerbMove, "North", 0},
+		{"go to @camp", VerbMove, "@camp", 0},
+		{"roll STR 15", VerbRoll, "STR", 15},
+		{"roll dex", VerbRoll, "DEX", 0},
+		{"map", VerbMap, "", 0},
+		{"maple", VerbNarrate, "", 0},
+		{"map export", VerbMapExport, "", 0},
+		{"look north", VerbPeek, "north", 0},
+		{"examine east", VerbPeek, "east", 0},
+		{"look around", VerbNarrate, "", 0},
+		{"look at the statue", VerbExamine, "the statue", 0},
+		{"search", VerbSearch, "the surroundings", 0},
+		{"set prune OFF", VerbSetPrune, "off", 0},
+		{"  Inventory  ", VerbInventory, "", 0},
+		{"dance wildly", VerbNarrate, "", 0},
+	}
+	for _, tt := range tests {
+		c := parseCommand(tt.in)
+		if c.Verb != tt.verb || c.Arg != tt.arg || c.N != tt.n {
+			t.Errorf("parseCommand(%q) = {%v %q %d}, want {%v %q %d}", tt.in, c.Verb, c.Arg, c.N, tt.verb, tt.arg, tt.n)
+		}
+	}
+}
+
+const okBody = `{"choices":[{"message":{"role":"assistant","content":" The door creaks open. "},"finish_reason":"stop"}]}`
+
+func TestRequestChat(t *testing.T) {
+	tests := []struct {
+		name     string
+		replies  []stubReply
+		content  string
+		err      error
+		attempts int
+	}{
+		{"valid reply", []stubReply{{200, okBody}}, "The door creaks open.", nil, 1},
+		{"rate limited then ok", []stubReply{{429, "slow down"}, {200, okBody}}, "The door creaks open.", nil, 2},
+		{"server error then ok", []stubReply{{500, "oops"}, {200, okBody}}, "The door creaks open.", nil, 2},
+		{"malformed json", []stubReply{{200, "{not json"}}, placeholderResponse, nil, 1},
+		{"retries exhausted", []stubReply{{500, "down"}}, placeholderResponse, ErrServer, 3},
+		{"auth is not retried", []stubReply{{401, "bad key"}}, placeholderResponse, ErrAuth, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := stubAPI(t, tt.replies...)
+			reply, _, err := requestChat([]Message{{Role: "user", Content: "open the door"}}, nil, time.Second)
+			if reply.Content != tt.content {
+				t.Errorf("content = %q, want %q", reply.Content, tt.content)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if tt.content == placeholderResponse && err == nil {
+				t.Error("a failed request returned no error")
+			}
+			if len(*seen) != tt.attempts {
+				t.Errorf("%d attempts, want %d", len(*seen), tt.attempts)
+			}
+		})
+	}
+}
+
+func TestApplyMarkersIgnoresMalformed(t *testing.T) {
+	old := playerState
+	t.Cleanup(func() { playerState = old })
+	playerState = PlayerState{Gold: 10, HP: 8, MaxHP: 10, Stats: map[string]int{"STR": 12}}
+	for _, m := range []string{"[GOLD+:x]", "[STAT:HP]", "[EFFECT:a:b]", "[GOLD-:-3]", "[STAT:STR:zero]", "[REP:Guard]", "[EFFECT:poison:2]"} {
+		text, changes := applyMarkers("The wind howls. " + m)
+		if text != "The wind howls. " {
+			t.Errorf("%s: text = %q, marker not stripped", m, text)
+		}
+		if len(changes) != 0 {
+			t.Errorf("%s: changes = %q, want none", m, changes)
+		}
+	}
+	if playerState.Gold != 10 || playerState.HP != 8 || playerState.Stats["STR"] != 12 || len(playerState.Reputation) != 0 || len(playerState.Effects) != 0 {
+		t.Errorf("malformed markers changed the player: %+v", playerState)
+	}
+	// a well-formed marker beside them still applies
+	if _, changes := applyMarkers("[GOLD+:x] [GOLD+:5]"); len(changes) != 1 || playerState.Gold != 15 {
+		t.Errorf("[GOLD+:5] gave %q and %d gold, want one change and 15", changes, playerState.Gold)
+	}
+}
+
+func TestWithStateDuringGameLoop(t *testing.T) {
+	oldInput, oldState := input, playerState
+	t.Cleanup(func() { input, playerState = oldInput, oldState })
+	playerState = PlayerState{}
+	pr, pw := io.Pipe()
+	input = bufio.NewReader(pr)
+	const turns = 50
+	// like the shutdown handler, this goroutine only gets in while main waits for input
+	go func() {
+		for i := 0; i < turns; i++ {
+			withState(func() { playerState.Gold++ })
+			io.WriteString(pw, "look\n")
+		}
+		pw.Close()
+	}()
+	lockState()
+	for {
+		playerState.Gold++
+		if _, err := readLine(); err != nil {
+			break
+		}
+	}
+	unlockState()
+	if playerState.Gold != 2*turns+1 {
+		t.Errorf("gold = %d, want %d", playerState.Gold, 2*turns+1)
+	}
+}
+
+func TestCleanList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"None", nil},
+		{"none.", nil},
+		{"", nil},
+		{"North, South, and East", []string{"North", "South", "East"}},
+		{"the Blacksmith, a Merchant\nan Old Hermit", []string{"Blacksmith", "Merchant", "Old Hermit"}},
+		{"and the Guard", []string{"Guard"}},
+		{"Mara, mara, MARA.", []string{"Mara"}},
+		{"*Tom*, \"Ann\"", []string{"Tom", "Ann"}},
+		{"Tom, there are no other people here at this time", []string{"Tom"}},
+		{"Andrew, Theodore", []string{"Andrew", "Theodore"}},
+		{"p1, p2, p3, p4, p5, p6, p7, p8, p9, p10, p11, p12, p13", []string{"p1", "p2", "p3", "p4", "p5", "p6", "p7", "p8", "p9", "p10", "p11", "p12"}},
+	}
+	for _, tt := range tests {
+		got := cleanList(tt.in)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("cleanList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenameLocationLeavesNoDanglingReferences(t *testing.T) {
+	old, oldScenes := playerState, sceneDescriptions
+	t.Cleanup(func() { playerState, sceneDescriptions = old, oldScenes })
+	sceneDescriptions = map[string]string{"Old Mill": "A creaking wheel."}
+	playerState = PlayerState{
+		CurrentLocation:  "Old Mill",
+		VisitedLocations: []string{"Village", "Old Mill"},
+		PathHistory:      []string{"Village", "Old Mill"},
+		MapGraph: map[string]map[string]bool{
+			"Village":  {"Old Mill": true, "Forest": true},
+			"Old Mill": {"Village": true},
+			"Forest":   {"Village": true},
+		},
+		Frontiers:  map[string]map[string]bool{"Old Mill": {"river": true}, "Forest": {"Old Mill": true}},
+		SceneItems: map[string][]string{"Old Mill": {"sack of flour"}},
+		Bookmarks:  map[string]string{"mill": "Old Mill", "home": "Village"},
+	}
+	renameLocation("Old Mill", "Watermill")
+	for _, g := range []map[string]map[string]bool{playerState.MapGraph, playerState.Frontiers} {
+		for a, ns := range g {
+			if a == "Old Mill" || ns["Old Mill"] {
+				t.Errorf("dangling reference to Old Mill in %v", g)
+			}
+		}
+	}
+	if !playerState.MapGraph["Village"]["Watermill"] || !playerState.MapGraph["Watermill"]["Village"] {
+		t.Errorf("map lost the renamed place's edges: %v", playerState.MapGraph)
+	}
+	if !playerState.Frontiers["Watermill"]["river"] || !playerState.Frontiers["Forest"]["Watermill"] {
+		t.Errorf("frontiers lost the renamed place: %v", playerState.Frontiers)
+	}
+	if playerState.Bookmarks["mill"] != "Watermill" || playerState.Bookmarks["home"] != "Village" {
+		t.Errorf("bookmarks = %v", playerState.Bookmarks)
+	}
+	if playerState.CurrentLocation != "Watermill" || contains(playerState.VisitedLocations, "Old Mill") || contains(playerState.PathHistory, "Old Mill") {
+		t.Errorf("location lists still mention Old Mill: %+v", playerState)
+	}
+	if _, ok := sceneDescriptions["Watermill"]; !ok || len(playerState.SceneItems["Watermill"]) != 1 {
+		t.Error("scene description or items were not carried over")
+	}
+}
+
+func TestSplitRename(t *testing.T) {
+	old := playerState
+	t.Cleanup(func() { playerState = old })
+	playerState = PlayerState{CurrentLocation: "Road to Town", VisitedLocations: []string{"Road to Town", "tower"}}
+	tests := []struct {
+		in, old, name string
+		ok            bool
+	}{
+		{"road to town to Old Road", "Road to Town", "Old Road", true},
+		{"tower TO Tower", "tower", "Tower", true},
+		{"tower To", "", "", false},
+		{"castle to Keep", "", "", false},
+	}
+	for _, tt := range tests {
+		o, n, ok := splitRename(tt.in)
+		if o != tt.old || n != tt.name || ok != tt.ok {
+			t.Errorf("splitRename(%q) = %q, %q, %v; want %q, %q, %v", tt.in, o, n, ok, tt.old, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestSaveAliasSurvivesReload(t *testing.T) {
+	oldState, oldNpcs, oldScenes, oldAmbient, oldItems := playerState, npcData, sceneDescriptions, ambientLines, itemsData
+	oldPrompt, oldTheme, oldSummary, oldAliases := systemPrompt, themeName, summaryPrompt, aliases
+	t.Cleanup(func() {
+		playerState, npcData, sceneDescriptions, ambientLines, itemsData = oldState, oldNpcs, oldScenes, oldAmbient, oldItems
+		systemPrompt, themeName, summaryPrompt, aliases = oldPrompt, oldTheme, oldSummary, oldAliases
+	})
+	aliases = map[string]string{"gt": "go to"}
+	playerState = PlayerState{CurrentLocation: "Harbor"}
+	setAlias("-s gt talk to")
+	path := t.TempDir() + "/save.json"
+	if err := writeSave(path, []Message{{Role: "system", Content: "You are the narrator."}}); err != nil {
+		t.Fatal(err)
+	}
+	playerState = PlayerState{}
+	if _, err := readSave(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := playerState.Aliases["gt"]; got != "talk to" {
+		t.Fatalf("reloaded save alias = %q, want %q", got, "talk to")
+	}
+	// dispatch parses the expanded line, so the save's alias beats the global one
+	c := parseCommand(expandAlias("gt Mara"))
+	if c.Verb != VerbTalk || c.Arg != "Mara" {
+		t.Errorf("gt Mara parsed as {%v %q}, want {talk %q}", c.Verb, c.Arg, "Mara")
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		code int
+		body string
+		want error
+	}{
+		{429, "rate limit reached", ErrRateLimited},
+		{401, "invalid api key", ErrAuth},
+		{403, "forbidden", ErrAuth},
+		{500, "internal error", ErrServer},
+		{503, "overloaded", ErrServer},
+		{400, `{"error":{"code":"context_length_exceeded"}}`, ErrContextLength},
+		{400, "bad request", nil},
+	}
+	sentinels := []error{ErrRateLimited, ErrAuth, ErrServer, ErrContextLength}
+	for _, tt := range tests {
+		err := statusError(tt.code, []byte(tt.body))
+		if err == nil {
+			t.Errorf("statusError(%d) = nil", tt.code)
+			continue
+		}
+		for _, s := range sentinels {
+			if got := errors.Is(err, s); got != (s == tt.want) {
+				t.Errorf("statusError(%d, %q): errors.Is(%v) = %v", tt.code, tt.body, s, got)
+			}
+		}
+	}
+}
+
+func TestRepairMap(t *testing.T) {
+	old := playerState
+	t.Cleanup(func() { playerState = old })
+	playerState = PlayerState{
+		CurrentLocation:  "Tavern",
+		VisitedLocations: []string{"Tavern", "Square", "Gate", "Forest"},
+		MapGraph: map[string]map[string]bool{
+			"Tavern": {"Square": true, "Tavern": true, "Nowhere": true, "Well": false},
+			"Square": {},
+			"Gate":   {"Square": true, "Forest": true},
+		},
+	}
+	if fixed := repairMap(); fixed != 6 {
+		t.Errorf("repairMap fixed %d edges, want 6", fixed)
+	}
+	g := playerState.MapGraph
+	for a, ns := range g {
+		for b, ok := range ns {
+			if !ok || a == b || b == "Nowhere" || b == "Well" {
+				t.Errorf("bad edge %s -> %s (%v) survived", a, b, ok)
+			}
+			if !g[b][a] {
+				t.Errorf("edge %s -> %s has no way back", a, b)
+			}
+		}
+	}
+	if !g["Square"]["Tavern"] || !g["Forest"]["Gate"] {
+		t.Errorf("missing edges were not restored: %v", g)
+	}
+	if fixed := repairMap(); fixed != 0 {
+		t.Errorf("repairing a sound map fixed %d edges", fixed)
+	}
+}
+
+func TestReadSaveDropsStaleCaches(t *testing.T) {
+	oldState, oldNpcs, oldScenes, oldAmbient, oldItems := playerState, npcData, sceneDescriptions, ambientLines, itemsData
+	oldPrompt, oldTheme := systemPrompt, themeName
+	t.Cleanup(func() {
+		playerState, npcData, sceneDescriptions, ambientLines, itemsData = oldState, oldNpcs, oldScenes, oldAmbient, oldItems
+		systemPrompt, themeName = oldPrompt, oldTheme
+	})
+	path := t.TempDir() + "/save.json"
+	if err := writeSave(path, []Message{{Role: "system", Content: "You are the narrator."}}); err != nil {
+		t.Fatal(err)
+	}
+	lastNpcs, lastNpcsLoc, lastItems, lastItemsLoc = []string{"Mara"}, "Harbor", []string{"rope"}, "Harbor"
+	recapText, recapTokens, regenReady = "Long ago...", 100, true
+	if _, err := readSave(path); err != nil {
+		t.Fatal(err)
+	}
+	if lastNpcs != nil || lastNpcsLoc != "" || lastItems != nil || lastItemsLoc != "" || recapText != "" || recapTokens != 0 || regenReady {
+		t.Error("caches from the previous game survived loading a save")
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	old := playerState
+	t.Cleanup(func() { playerState = old })
+	link := func(g map[string]map[string]bool, a, b string) {
+		for _, e := range [][2]string{{a, b}, {b, a}} {
+			if g[e[0]] == nil {
+				g[e[0]] = map[string]bool{}
+			}
+			g[e[0]][e[1]] = true
+		}
+	}
+	g := map[string]map[string]bool{}
+	link(g, "Camp", "Cliffs")
+	link(g, "Camp", "Bridge")
+	link(g, "Bridge", "Keep")
+	link(g, "Cliffs", "Keep")
+	link(g, "Keep", "Tower")
+	link(g, "Isle", "Lighthouse")
+	playerState = PlayerState{MapGraph: g}
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{"Camp", "Camp", []string{"Camp"}},
+		{"Camp", "Isle", nil},
+		{"Camp", "Nowhere", nil},
+		{"Camp", "Bridge", []string{"Camp", "Bridge"}},
+		// two equally short routes: the alphabetically first neighbour wins
+		{"Camp", "Tower", []string{"Camp", "Bridge", "Keep", "Tower"}},
+		{"Tower", "Camp", []string{"Tower", "Keep", "Bridge", "Camp"}},
+	}
+	for _, tt := range tests {
+		// map order varies from run to run, so ask more than once
+		for i := 0; i < 20; i++ {
+			got := shortestPath(tt.from, tt.to)
+			if strings.Join(got, ">") != strings.Join(tt.want, ">") || (got == nil) != (tt.want == nil) {
+				t.Fatalf("shortestPath(%q, %q) = %q, want %q", tt.from, tt.to, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestOrgAndProjectHeaders(t *testing.T) {
+	oldOrg, oldProject := globalOrg, globalProject
+	t.Cleanup(func() { globalOrg, globalProject = oldOrg, oldProject })
+	tests := []struct{ org, project string }{
+		{"", ""},
+		{"org-123", ""},
+		{"", "proj_abc"},
+		{"org-123", "proj_abc"},
+	}
+	for _, tt := range tests {
+		globalOrg, globalProject = tt.org, tt.project
+		seen := stubAPI(t, stubReply{200, okBody})
+		requestChat([]Message{{Role: "user", Content: "hello"}}, nil, time.Second)
+		h := (*seen)[0].Header
+		for name, want := range map[string]string{"OpenAI-Organization": tt.org, "OpenAI-Project": tt.project} {
+			if sent := len(h.Values(name)) > 0; sent != (want != "") || h.Get(name) != want {
+				t.Errorf("org %q, project %q: %s header = %q (sent %v)", tt.org, tt.project, name, h.Get(name), sent)
+			}
+		}
+	}
+}